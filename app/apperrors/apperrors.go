@@ -0,0 +1,120 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// AppError is an error that knows how it should be shown to API clients
+type AppError struct {
+	// HTTP status of the response
+	Status int
+	// Stable, machine readable code clients can switch on
+	Code string
+	// Public message, safe to show to anyone
+	Message string
+	// Public extra data, e.g. the invalid fields of a request
+	Details interface{}
+	// Internal cause, only ever shown in debug mode
+	Err error
+}
+
+// Error implements error, it includes the internal cause so logs stay useful
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap exposes the internal cause to errors.Is and errors.As
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// WithDetails attaches public extra data to the error
+func (e *AppError) WithDetails(details interface{}) *AppError {
+	e.Details = details
+	return e
+}
+
+// New creates an AppError without an internal cause
+func New(status int, code, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message}
+}
+
+// Wrap creates an AppError keeping err as the internal cause
+func Wrap(err error, status int, code, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message, Err: err}
+}
+
+// BadRequest is a 400 for requests the server can not make sense of
+func BadRequest(code, message string) *AppError {
+	return New(fiber.StatusBadRequest, code, message)
+}
+
+// InvalidBody is a 400 for bodies that could not be parsed, err is what the parser said
+func InvalidBody(err error) *AppError {
+	return Wrap(err, fiber.StatusBadRequest, "invalid_body", "The request body could not be parsed.")
+}
+
+// NotFound is a 404 for resources that do not exist
+func NotFound(code, message string) *AppError {
+	return New(fiber.StatusNotFound, code, message)
+}
+
+// Conflict is a 409 for requests that clash with the current state
+func Conflict(code, message string) *AppError {
+	return New(fiber.StatusConflict, code, message)
+}
+
+// Internal is a 500 hiding err behind a generic message
+func Internal(err error) *AppError {
+	return Wrap(err, fiber.StatusInternalServerError, "internal_error", "Something went wrong on our side.")
+}
+
+// From turns any error into an AppError, recognizing the usual suspects on the way
+func From(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	// fiber errors are generated by fiber itself (unknown routes, etc.), their messages are safe
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return Wrap(err, fiberErr.Code, codeFromStatus(fiberErr.Code), fiberErr.Message)
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Wrap(err, fiber.StatusNotFound, "not_found", "The requested resource was not found.")
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return Wrap(err, fiber.StatusConflict, "conflict", "The resource conflicts with an existing one.")
+		case "22P02", "22003":
+			// invalid_text_representation, numeric_value_out_of_range: a bad id in the URL and the like
+			return Wrap(err, fiber.StatusBadRequest, "invalid_parameter", "A parameter of the request has an invalid value.")
+		}
+	}
+
+	return Internal(err)
+}
+
+// codeFromStatus turns 404 into not_found, 405 into method_not_allowed and so on
+func codeFromStatus(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error_" + strconv.Itoa(status)
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
@@ -0,0 +1,66 @@
+package auth
+
+import "time"
+
+// Config of the accounts and their tokens
+type Config struct {
+	// Issuer (iss) written into the tokens and required on the way back in, left out when empty
+	Issuer string
+	// Audience (aud) written into the tokens and required on the way back in, left out when empty
+	Audience string
+	// Algorithm the tokens are signed with, HS256/384/512 use Secret,
+	// RS256/384/512, PS256/384/512 and ES256/384/512 use the PEM key files
+	Algorithm string
+	// Secret of the HMAC algorithms, at least as long as their hash (32 bytes for HS256).
+	// It is read from the AUTH_SECRET environment variable, never from the config files
+	Secret string
+	// PEM keys of the RSA and ECDSA algorithms, an instance with only the public key verifies tokens but can't issue them
+	PrivateKeyFile string
+	PublicKeyFile  string
+	// How long an access token is valid
+	AccessTokenTTL time.Duration
+	// How long a refresh token is valid, every refresh hands out a new one so a session lasts as long as it is used
+	RefreshTokenTTL time.Duration
+	// How often sessions whose last refresh token expired are removed
+	PurgeInterval time.Duration
+	// How long an instance trusts what it last read about the session of an access token,
+	// the access tokens of a revoked session keep working for up to this long. 0 reads it on every request
+	RevocationCheckTTL time.Duration
+	// Clock difference tolerated between the issuer and the verifier when checking exp, nbf and iat
+	Leeway time.Duration
+	// Password is the policy new passwords have to follow
+	Password PasswordPolicy
+}
+
+// ConfigDefault is used for what auth.yaml leaves out
+var ConfigDefault = Config{
+	Issuer:             "fiber-crayplate",
+	Algorithm:          "HS256",
+	AccessTokenTTL:     15 * time.Minute,
+	RefreshTokenTTL:    30 * 24 * time.Hour,
+	PurgeInterval:      time.Hour,
+	Leeway:             30 * time.Second,
+	RevocationCheckTTL: 30 * time.Second,
+	Password: PasswordPolicy{
+		MinLength:    10,
+		MaxLength:    128,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	},
+}
+
+var current = ConfigDefault
+
+// Configure sets the policy passwords are checked against, the tokens are set up by NewTokens
+func Configure(config Config) {
+	if config.Password.MaxLength <= 0 {
+		config.Password.MaxLength = ConfigDefault.Password.MaxLength
+	}
+	current = config
+}
+
+// Policy is the password policy in use
+func Policy() PasswordPolicy {
+	return current.Password
+}
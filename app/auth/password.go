@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	hashing "github.com/thomasvvugt/fiber-hashing"
+)
+
+// PasswordPolicy is what a new password must look like
+type PasswordPolicy struct {
+	// Shortest password allowed, in characters
+	MinLength int
+	// Longest password allowed, in characters, so nobody has us hash a megabyte
+	MaxLength int
+	// A password needs at least one character of every kind required
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// Check returns every rule the password breaks as errors of field, nil when it follows the policy.
+// The messages never repeat the password
+func (p PasswordPolicy) Check(field, password string) validation.Errors {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	var errs validation.Errors
+	fail := func(code, message string) {
+		errs = append(errs, validation.FieldError{Field: field, Code: code, Message: message})
+	}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		fail("min", "must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		fail("max", "must be at most "+strconv.Itoa(p.MaxLength)+" characters long")
+	}
+	if p.RequireUpper && !upper {
+		fail("uppercase", "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		fail("lowercase", "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		fail("digit", "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		fail("symbol", "must contain a symbol")
+	}
+	return errs
+}
+
+var (
+	decoyOnce sync.Once
+	decoy     string
+	decoyErr  error
+)
+
+// VerifyPassword compares password with hash in constant time. An empty hash, for an account that doesn't exist
+// or has no password, is still compared against a throwaway hash, so the time a login takes doesn't tell
+// whether the account exists
+func VerifyPassword(driver hashing.Driver, password, hash string) (bool, error) {
+	if hash != "" {
+		return driver.MatchHash(password, hash)
+	}
+
+	decoyOnce.Do(func() {
+		raw := make([]byte, 16)
+		if _, decoyErr = rand.Read(raw); decoyErr == nil {
+			decoy, decoyErr = driver.CreateHash(hex.EncodeToString(raw))
+		}
+	})
+	if decoyErr != nil {
+		return false, fmt.Errorf("error creating the decoy password hash: %w", decoyErr)
+	}
+	_, err := driver.MatchHash(password, decoy)
+	return false, err
+}
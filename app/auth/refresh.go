@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// NewRefreshToken generates an opaque refresh token and the hash it is stored under,
+// the token itself is only ever handed to the client
+func NewRefreshToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("auth: generating a refresh token: %w", err)
+	}
+	token = "rt_" + base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken is the hash a refresh token is stored and looked up under. A refresh token is 256 random bits,
+// nothing to guess from, so a plain SHA-256 is enough and lookups stay an index hit
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSessionID generates the id of a session, the family of refresh tokens rotated out of one login
+func NewSessionID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("auth: generating a session id: %w", err)
+	}
+	return "ses_" + hex.EncodeToString(raw), nil
+}
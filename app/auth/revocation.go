@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// revocationLimit is how many sessions Revocations remembers before it forgets the stale ones
+const revocationLimit = 10000
+
+// Revocations tells whether the session an access token was issued for has ended, by logout or because
+// one of its refresh tokens was replayed. Access tokens are checked on every request, so what the database
+// says about a session is remembered for a while, a revoked session's tokens stop working within that time
+type Revocations struct {
+	pool *pgxpool.Pool
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	checked map[string]revocation
+}
+
+type revocation struct {
+	revoked bool
+	at      time.Time
+}
+
+// NewRevocations creates Revocations on top of the pool, a ttl of 0 asks the database on every request
+func NewRevocations(pool *pgxpool.Pool, ttl time.Duration) *Revocations {
+	return &Revocations{pool: pool, ttl: ttl, checked: make(map[string]revocation)}
+}
+
+// Revoked is true when the session was revoked or is gone, purged sessions ended long before they are removed
+func (r *Revocations) Revoked(c context.Context, sessionID string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	known, ok := r.checked[sessionID]
+	r.mu.Unlock()
+	// a revoked session never comes back, there is nothing more to ask about it
+	if ok && (known.revoked || now.Sub(known.at) < r.ttl) {
+		return known.revoked, nil
+	}
+
+	revoked := false
+	err := r.pool.QueryRow(c, "SELECT revoked_at IS NOT NULL FROM sessions WHERE session_id = $1", sessionID).Scan(&revoked)
+	if err == pgx.ErrNoRows {
+		revoked, err = true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking the session of an access token: %w", err)
+	}
+	if r.ttl <= 0 {
+		return revoked, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.checked) >= revocationLimit {
+		for id, entry := range r.checked {
+			if now.Sub(entry.at) >= r.ttl {
+				delete(r.checked, id)
+			}
+		}
+		// every entry is fresh, start over rather than grow without end
+		if len(r.checked) >= revocationLimit {
+			r.checked = make(map[string]revocation)
+		}
+	}
+	r.checked[sessionID] = revocation{revoked: revoked, at: now}
+	return revoked, nil
+}
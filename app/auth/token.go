@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Errors of Parse
+var (
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrExpiredToken = errors.New("auth: token expired")
+)
+
+// placeholderSecret is the example secret the config used to ship with, anyone can sign tokens with it
+const placeholderSecret = "change-me-to-at-least-32-random-bytes"
+
+// ClaimsKey is where Authenticate leaves the claims of a request, in the fiber locals
+// and so in the context.Context of the request too
+const ClaimsKey = "auth.claims"
+
+// Claims of an access token, the subject is the id of the user
+type Claims struct {
+	jwt.StandardClaims
+	// SessionID is the login the token was issued for, the session its refresh tokens belong to
+	SessionID string `json:"sid,omitempty"`
+}
+
+// UserID is the user the token was issued to
+func (c *Claims) UserID() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
+}
+
+// ClaimsFrom returns the claims of an authenticated request, nil when the request carried no token
+// or went through a route that isn't protected
+func ClaimsFrom(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(ClaimsKey).(*Claims)
+	return claims
+}
+
+// Tokens issues and verifies signed access tokens
+type Tokens struct {
+	config Config
+	method jwt.SigningMethod
+	// signKey is nil for an instance that only verifies tokens issued elsewhere
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewTokens checks the algorithm and loads the keys of config, HMAC algorithms use Secret,
+// RSA and ECDSA ones the PEM files, a missing public key is derived from the private one
+func NewTokens(config Config) (*Tokens, error) {
+	method := jwt.GetSigningMethod(config.Algorithm)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("auth: unsupported signing algorithm %q", config.Algorithm)
+	}
+	if config.AccessTokenTTL <= 0 {
+		config.AccessTokenTTL = ConfigDefault.AccessTokenTTL
+	}
+	if config.RefreshTokenTTL <= 0 {
+		config.RefreshTokenTTL = ConfigDefault.RefreshTokenTTL
+	}
+	t := &Tokens{config: config, method: method}
+
+	switch m := method.(type) {
+	case *jwt.SigningMethodHMAC:
+		// without a secret of its own an instance would accept tokens signed by anyone who read the docs,
+		// and a secret shorter than the hash is weaker than the algorithm promises
+		if config.Secret == "" || config.Secret == placeholderSecret {
+			return nil, fmt.Errorf("auth: %s needs a Secret, set the AUTH_SECRET environment variable to at least %d random bytes", m.Alg(), m.Hash.Size())
+		}
+		if len(config.Secret) < m.Hash.Size() {
+			return nil, fmt.Errorf("auth: Secret must be at least %d bytes for %s", m.Hash.Size(), m.Alg())
+		}
+		t.signKey, t.verifyKey = []byte(config.Secret), []byte(config.Secret)
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private := func(pem []byte) (crypto.PrivateKey, error) { return jwt.ParseRSAPrivateKeyFromPEM(pem) }
+		public := func(pem []byte) (crypto.PublicKey, error) { return jwt.ParseRSAPublicKeyFromPEM(pem) }
+		if err := t.loadKeys(private, public); err != nil {
+			return nil, err
+		}
+	case *jwt.SigningMethodECDSA:
+		private := func(pem []byte) (crypto.PrivateKey, error) { return jwt.ParseECPrivateKeyFromPEM(pem) }
+		public := func(pem []byte) (crypto.PublicKey, error) { return jwt.ParseECPublicKeyFromPEM(pem) }
+		if err := t.loadKeys(private, public); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("auth: unsupported signing algorithm %q", config.Algorithm)
+	}
+	return t, nil
+}
+
+func (t *Tokens) loadKeys(private func([]byte) (crypto.PrivateKey, error), public func([]byte) (crypto.PublicKey, error)) error {
+	if t.config.PrivateKeyFile != "" {
+		pem, err := ioutil.ReadFile(t.config.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("auth: reading the private key: %w", err)
+		}
+		key, err := private(pem)
+		if err != nil {
+			return fmt.Errorf("auth: parsing the private key: %w", err)
+		}
+		t.signKey = key
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			t.verifyKey = &k.PublicKey
+		case *ecdsa.PrivateKey:
+			t.verifyKey = &k.PublicKey
+		}
+	}
+	if t.config.PublicKeyFile != "" {
+		pem, err := ioutil.ReadFile(t.config.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("auth: reading the public key: %w", err)
+		}
+		key, err := public(pem)
+		if err != nil {
+			return fmt.Errorf("auth: parsing the public key: %w", err)
+		}
+		t.verifyKey = key
+	}
+	if t.verifyKey == nil {
+		return fmt.Errorf("auth: %s needs PrivateKeyFile or PublicKeyFile", t.method.Alg())
+	}
+	return nil
+}
+
+// Issue signs an access token for the user and session, valid for AccessTokenTTL from now
+func (t *Tokens) Issue(userID int, sessionID string) (string, *Claims, error) {
+	if t.signKey == nil {
+		return "", nil, fmt.Errorf("auth: tokens can't be issued without PrivateKeyFile")
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("auth: generating a token id: %w", err)
+	}
+
+	now := time.Now()
+	claims := &Claims{StandardClaims: jwt.StandardClaims{
+		Id:        hex.EncodeToString(raw),
+		Issuer:    t.config.Issuer,
+		Audience:  t.config.Audience,
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(t.config.AccessTokenTTL).Unix(),
+	}, SessionID: sessionID}
+	token, err := jwt.NewWithClaims(t.method, claims).SignedString(t.signKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("auth: signing a token: %w", err)
+	}
+	return token, claims, nil
+}
+
+// TTL is how long the access tokens are valid
+func (t *Tokens) TTL() time.Duration {
+	return t.config.AccessTokenTTL
+}
+
+// RefreshTTL is how long the refresh tokens are valid
+func (t *Tokens) RefreshTTL() time.Duration {
+	return t.config.RefreshTokenTTL
+}
+
+// Parse verifies a token and returns its claims. Only the configured algorithm is accepted, a token can't pick
+// its own, and the issuer and audience have to match when they are configured. Times are checked with Leeway
+func (t *Tokens) Parse(token string, now time.Time) (*Claims, error) {
+	claims := &Claims{}
+	parser := &jwt.Parser{ValidMethods: []string{t.method.Alg()}, SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return t.verifyKey, nil }); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	leeway := int64(t.config.Leeway / time.Second)
+	if !claims.VerifyExpiresAt(now.Unix()-leeway, true) {
+		return nil, ErrExpiredToken
+	}
+	if !claims.VerifyNotBefore(now.Unix()+leeway, false) || !claims.VerifyIssuedAt(now.Unix()+leeway, false) {
+		return nil, ErrInvalidToken
+	}
+	if t.config.Issuer != "" && !claims.VerifyIssuer(t.config.Issuer, true) {
+		return nil, ErrInvalidToken
+	}
+	if t.config.Audience != "" && !claims.VerifyAudience(t.config.Audience, true) {
+		return nil, ErrInvalidToken
+	}
+	if claims.UserID() <= 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
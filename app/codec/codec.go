@@ -0,0 +1,169 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// Naming strategies for untagged struct fields
+const (
+	SnakeCase = "snake_case"
+	CamelCase = "camelCase"
+)
+
+// Config of the JSON codec used for every response
+type Config struct {
+	// How untagged struct fields are named, snake_case (UserId becomes user_id) or camelCase (userId)
+	NamingStrategy string
+	// Leave empty untagged fields out, fields with an explicit json tag keep what the tag says
+	OmitEmpty bool
+	// Escape <, > and & inside strings
+	EscapeHTML bool
+}
+
+// ConfigDefault is what the API speaks until Configure is called
+var ConfigDefault = Config{
+	NamingStrategy: SnakeCase,
+	OmitEmpty:      false,
+	EscapeHTML:     true,
+}
+
+var (
+	api       = build(ConfigDefault)
+	translate = snakeCase
+)
+
+// Configure builds the codec, call it once at startup before the server starts listening
+func Configure(config Config) error {
+	if config.NamingStrategy == "" {
+		config.NamingStrategy = ConfigDefault.NamingStrategy
+	}
+	if _, ok := strategies[config.NamingStrategy]; !ok {
+		return fmt.Errorf("unknown JSON naming strategy %q, use %s or %s", config.NamingStrategy, SnakeCase, CamelCase)
+	}
+	api = build(config)
+	translate = strategies[config.NamingStrategy]
+	return nil
+}
+
+// JSON is the configured codec
+func JSON() jsoniter.API {
+	return api
+}
+
+// Marshal encodes v with the configured codec
+func Marshal(v interface{}) ([]byte, error) {
+	return api.Marshal(v)
+}
+
+// Unmarshal decodes data with the configured codec
+func Unmarshal(data []byte, v interface{}) error {
+	return api.Unmarshal(data, v)
+}
+
+// Name is the JSON name of an untagged Go field, UserId becomes user_id or userId
+func Name(field string) string {
+	return translate(field)
+}
+
+// Key names a map key the same way struct fields are named, keys are written in snake_case
+func Key(snake string) string {
+	if strings.IndexByte(snake, '_') < 0 {
+		return snake
+	}
+	parts := strings.Split(snake, "_")
+	for i := range parts {
+		if len(parts[i]) > 0 {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return translate(strings.Join(parts, ""))
+}
+
+var strategies = map[string]func(string) string{
+	SnakeCase: snakeCase,
+	CamelCase: camelCase,
+}
+
+func build(config Config) jsoniter.API {
+	// every codec gets its own extension, nothing is registered on jsoniter's global state
+	frozen := jsoniter.Config{
+		EscapeHTML:             config.EscapeHTML,
+		SortMapKeys:            true,
+		ValidateJsonRawMessage: true,
+	}.Froze()
+	frozen.RegisterExtension(&fieldsExtension{
+		translate: strategies[config.NamingStrategy],
+		omitEmpty: config.OmitEmpty,
+	})
+	return frozen
+}
+
+// fieldsExtension renames untagged fields and applies the omit empty policy
+type fieldsExtension struct {
+	jsoniter.DummyExtension
+	translate func(string) string
+	omitEmpty bool
+}
+
+func (extension *fieldsExtension) UpdateStructDescriptor(structDescriptor *jsoniter.StructDescriptor) {
+	for _, binding := range structDescriptor.Fields {
+		if unicode.IsLower(rune(binding.Field.Name()[0])) || binding.Field.Name()[0] == '_' {
+			continue
+		}
+		tag, hastag := binding.Field.Tag().Lookup("json")
+		if hastag {
+			tagParts := strings.Split(tag, ",")
+			if tagParts[0] == "-" {
+				continue // hidden field
+			}
+			if tagParts[0] != "" {
+				continue // field explicitly named
+			}
+		}
+		if extension.translate != nil {
+			binding.ToNames = []string{extension.translate(binding.Field.Name())}
+			binding.FromNames = []string{extension.translate(binding.Field.Name())}
+		}
+		if extension.omitEmpty && !strings.Contains(tag, "omitempty") {
+			binding.Field = omitEmptyField{binding.Field, tag}
+		}
+	}
+}
+
+// omitEmptyField makes jsoniter read an omitempty that is not in the source
+type omitEmptyField struct {
+	reflect2.StructField
+	tag string
+}
+
+func (field omitEmptyField) Tag() reflect.StructTag {
+	return reflect.StructTag(`json:"` + field.tag + `,omitempty"`)
+}
+
+// snakeCase changes UserId to user_id
+func snakeCase(name string) string {
+	newName := make([]rune, 0, len(name)+2)
+	for i, c := range name {
+		if i > 0 && unicode.IsUpper(c) {
+			newName = append(newName, '_')
+		}
+		newName = append(newName, unicode.ToLower(c))
+	}
+	return string(newName)
+}
+
+// camelCase changes UserId to userId
+func camelCase(name string) string {
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
@@ -9,6 +9,8 @@ type ApplicationConfiguration struct {
 	Listen      string
 	SuppressWWW bool
 	ForceHTTPS  bool
+	// Show internal error details in responses, never turn this on in production
+	Debug bool
 }
 
 func loadApplicationConfiguration() (ApplicationConfiguration, error) {
@@ -48,4 +50,5 @@ func setDefaultApplicationConfiguration(provider *viper.Viper) {
 	provider.SetDefault("Listen", "8080")
 	provider.SetDefault("SuppressWWW", true)
 	provider.SetDefault("ForceHTTPS", false)
+	provider.SetDefault("Debug", false)
 }
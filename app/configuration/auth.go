@@ -0,0 +1,60 @@
+package configuration
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+)
+
+func loadAuthConfiguration() (enabled bool, config auth.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("auth")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultAuthConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return provider.GetBool("Enabled"), config, err
+		}
+	}
+
+	// Unmarshal the configuration file into auth.Config
+	err = provider.Unmarshal(&config)
+
+	// The HMAC secret only comes from the environment, a secret in a committed file is everyone's secret
+	config.Secret = os.Getenv("AUTH_SECRET")
+
+	// Return the configuration (and error if occurred)
+	return provider.GetBool("Enabled"), config, err
+}
+
+// Set default configuration for the accounts and their tokens
+func setDefaultAuthConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Enabled", true)
+	provider.SetDefault("Issuer", auth.ConfigDefault.Issuer)
+	provider.SetDefault("Audience", auth.ConfigDefault.Audience)
+	provider.SetDefault("Algorithm", auth.ConfigDefault.Algorithm)
+	provider.SetDefault("AccessTokenTTL", auth.ConfigDefault.AccessTokenTTL)
+	provider.SetDefault("RefreshTokenTTL", auth.ConfigDefault.RefreshTokenTTL)
+	provider.SetDefault("PurgeInterval", auth.ConfigDefault.PurgeInterval)
+	provider.SetDefault("Leeway", auth.ConfigDefault.Leeway)
+	provider.SetDefault("RevocationCheckTTL", auth.ConfigDefault.RevocationCheckTTL)
+	provider.SetDefault("Password.MinLength", auth.ConfigDefault.Password.MinLength)
+	provider.SetDefault("Password.MaxLength", auth.ConfigDefault.Password.MaxLength)
+	provider.SetDefault("Password.RequireUpper", auth.ConfigDefault.Password.RequireUpper)
+	provider.SetDefault("Password.RequireLower", auth.ConfigDefault.Password.RequireLower)
+	provider.SetDefault("Password.RequireDigit", auth.ConfigDefault.Password.RequireDigit)
+	provider.SetDefault("Password.RequireSymbol", auth.ConfigDefault.Password.RequireSymbol)
+}
@@ -0,0 +1,42 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/httpcache"
+)
+
+func loadCacheConfiguration() (config httpcache.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("cache")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultCacheConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return config, err
+		}
+	}
+
+	// Unmarshal the configuration file into httpcache.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return config, err
+}
+
+// Set default configuration for the Cache-Control headers
+func setDefaultCacheConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Default", httpcache.ConfigDefault.Default)
+	provider.SetDefault("Routes", map[string]string{})
+}
@@ -5,8 +5,18 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/helmet/v2"
 
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/gql"
+	"github.com/mikeychowy/fiber-crayplate/app/httpcache"
+	"github.com/mikeychowy/fiber-crayplate/app/idempotency"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/versioning"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+
 	hashing "github.com/thomasvvugt/fiber-hashing"
 )
 
@@ -14,17 +24,27 @@ import (
 type Configuration struct {
 	Fiber          fiber.Config
 	App            ApplicationConfiguration
+	JSON           codec.Config
+	Response       response.Config
+	Cache          httpcache.Config
 	Enabled        map[string]bool
 	Logger         logger.Config
+	RequestID      requestid.Config
 	TemplateEngine func(raw string, bind interface{}) (out string, err error)
 	Compression    compress.Config
 	CORS           cors.Config
 	Helmet         helmet.Config
 	Hash           hashing.Config
+	Auth           auth.Config
 	PublicPrefix   string
 	PublicRoot     string
 	Public         fiber.Static
 	Database       DatabaseConfiguration
+	Users          UsersConfiguration
+	Idempotency    idempotency.Config
+	Versioning     versioning.Config
+	GraphQL        gql.Config
+	Webhooks       webhooks.Config
 }
 
 // LoadConfigurations using viper
@@ -44,6 +64,30 @@ func LoadConfigurations() (config Configuration, err error) {
 	}
 	config.App = appConfig
 
+	// The error handler needs to know whether internal details may be shown
+	config.Fiber.ErrorHandler = newAPIErrorHandler(appConfig.Debug)
+
+	// Load the JSON codec configuration
+	jsonConfig, err := loadJSONConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.JSON = jsonConfig
+
+	// Load the response formats configuration
+	responseConfig, err := loadResponseConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Response = responseConfig
+
+	// Load the Cache-Control configuration
+	cacheConfig, err := loadCacheConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Cache = cacheConfig
+
 	// Load the logger middleware configuration
 	loggerEnabled, loggerConfig, err := loadLoggerConfiguration()
 	if err != nil {
@@ -52,6 +96,15 @@ func LoadConfigurations() (config Configuration, err error) {
 	config.Enabled["logger"] = loggerEnabled
 	config.Logger = loggerConfig
 
+	// Load the request ID middleware configuration, problems read the id from the same header
+	requestIDEnabled, requestIDConfig, err := loadRequestIDConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Enabled["requestid"] = requestIDEnabled
+	config.RequestID = requestIDConfig
+	config.Response.RequestIDHeader = requestIDConfig.Header
+
 	// Load the recover middleware configuration
 	recoverEnabled, err := loadRecoverConfiguration()
 	if err != nil {
@@ -91,6 +144,14 @@ func LoadConfigurations() (config Configuration, err error) {
 	config.Enabled["hash"] = hashEnabled
 	config.Hash = hashConfig
 
+	// Load the accounts and tokens configuration
+	authEnabled, authConfig, err := loadAuthConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Enabled["auth"] = authEnabled
+	config.Auth = authConfig
+
 	// Load the database configuration
 	databaseEnabled, databaseConfig, err := loadDatabaseConfiguration()
 	if err != nil {
@@ -99,6 +160,47 @@ func LoadConfigurations() (config Configuration, err error) {
 	config.Enabled["database"] = databaseEnabled
 	config.Database = databaseConfig
 
+	// Load the users resource configuration
+	usersConfig, err := loadUsersConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Users = usersConfig
+
+	// Load the Idempotency-Key middleware configuration
+	idempotencyEnabled, idempotencyConfig, err := loadIdempotencyConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Enabled["idempotency"] = idempotencyEnabled
+	config.Idempotency = idempotencyConfig
+
+	// Load the API versions configuration
+	versioningConfig, err := loadVersioningConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Versioning = versioningConfig
+
+	// Load the GraphQL endpoint configuration, errors show their cause in debug mode like everywhere else
+	graphqlEnabled, graphqlConfig, err := loadGraphQLConfiguration()
+	if err != nil {
+		return config, err
+	}
+	graphqlConfig.Debug = appConfig.Debug
+	// lists without first: are as long as the REST pages, the complexity has to count them the same
+	graphqlConfig.DefaultListSize, graphqlConfig.MaxListSize = usersConfig.DefaultPageSize, usersConfig.MaxPageSize
+	config.Enabled["graphql"] = graphqlEnabled
+	config.GraphQL = graphqlConfig
+
+	// Load the webhook deliveries configuration
+	webhooksEnabled, webhooksConfig, err := loadWebhooksConfiguration()
+	if err != nil {
+		return config, err
+	}
+	config.Enabled["webhooks"] = webhooksEnabled
+	config.Webhooks = webhooksConfig
+
 	// Return the configuration
 	return config, nil
 }
@@ -10,6 +10,8 @@ type DatabaseConfiguration struct {
 	Username string
 	Password string
 	Database string
+	// Apply pending schema migrations right after connecting
+	AutoMigrate bool
 }
 
 func loadDatabaseConfiguration() (enabled bool, config DatabaseConfiguration, err error) {
@@ -49,4 +51,5 @@ func setDefaultDatabaseConfiguration(provider *viper.Viper) {
 	provider.SetDefault("Username", "postgres")
 	provider.SetDefault("Password", "secret")
 	provider.SetDefault("Database", "fiber")
+	provider.SetDefault("AutoMigrate", true)
 }
@@ -1,10 +1,11 @@
 package configuration
 
 import (
-	"fmt"
-
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+
 	"github.com/spf13/viper"
 )
 
@@ -60,25 +61,23 @@ func setDefaultFiberConfiguration(provider *viper.Viper) {
 	provider.SetDefault("CompressedFileSuffix", ".fiber.gz")
 	provider.SetDefault("ProxyHeader", "")
 	provider.SetDefault("GETOnly", false)
-	provider.SetDefault("ErrorHandler", defaultAPIErrorHandler)
 }
 
-// Default Error Handler
-var defaultAPIErrorHandler = func(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
-	if e, ok := err.(*fiber.Error); ok {
-		code = e.Code
-	}
-	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
-	c.Status(code)
-	err = c.JSON(fiber.Map{
-		"success": false,
-		"status":  code,
-		"message": fmt.Sprintf("%s", err),
-		"data":    make([]int, 0, 1),
-	})
-	if err != nil {
-		return c.Status(500).SendString("Internal Server Error")
+// newAPIErrorHandler maps every error to the standard response, internal details only leave the server in debug mode,
+// it goes through the response package so errors are serialized exactly like the handlers' responses
+func newAPIErrorHandler(debug bool) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		appErr := apperrors.From(err)
+
+		// the data part carries the public details of the error, like the invalid fields of a request
+		options := []response.Option{response.WithCode(appErr.Code)}
+		if debug && appErr.Err != nil {
+			options = append(options, response.WithDebug(appErr.Err.Error()))
+		}
+
+		if err := response.Send(c, appErr.Status, appErr.Message, appErr.Details, options...); err != nil {
+			return c.Status(500).SendString("Internal Server Error")
+		}
+		return nil
 	}
-	return nil
 }
@@ -0,0 +1,44 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/gql"
+)
+
+func loadGraphQLConfiguration() (enabled bool, config gql.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("graphql")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultGraphQLConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return provider.GetBool("Enabled"), config, err
+		}
+	}
+
+	// Unmarshal the configuration file into gql.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return provider.GetBool("Enabled"), config, err
+}
+
+// Set default configuration for the GraphQL endpoint
+func setDefaultGraphQLConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Enabled", true)
+	provider.SetDefault("MaxDepth", gql.ConfigDefault.MaxDepth)
+	provider.SetDefault("MaxIntrospectionDepth", gql.ConfigDefault.MaxIntrospectionDepth)
+	provider.SetDefault("MaxComplexity", gql.ConfigDefault.MaxComplexity)
+}
@@ -0,0 +1,46 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/idempotency"
+)
+
+func loadIdempotencyConfiguration() (enabled bool, config idempotency.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("idempotency")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultIdempotencyConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return provider.GetBool("Enabled"), config, err
+		}
+	}
+
+	// Unmarshal the configuration file into idempotency.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return provider.GetBool("Enabled"), config, err
+}
+
+// Set default configuration for the Idempotency-Key middleware
+func setDefaultIdempotencyConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Enabled", true)
+	provider.SetDefault("Header", idempotency.ConfigDefault.Header)
+	provider.SetDefault("TTL", idempotency.ConfigDefault.TTL)
+	provider.SetDefault("Methods", idempotency.ConfigDefault.Methods)
+	provider.SetDefault("MaxKeyLength", idempotency.ConfigDefault.MaxKeyLength)
+	provider.SetDefault("PurgeInterval", idempotency.ConfigDefault.PurgeInterval)
+}
@@ -0,0 +1,43 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+func loadJSONConfiguration() (config codec.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("json")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultJSONConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return config, err
+		}
+	}
+
+	// Unmarshal the configuration file into codec.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return config, err
+}
+
+// Set default configuration for the JSON codec
+func setDefaultJSONConfiguration(provider *viper.Viper) {
+	provider.SetDefault("NamingStrategy", codec.ConfigDefault.NamingStrategy)
+	provider.SetDefault("OmitEmpty", codec.ConfigDefault.OmitEmpty)
+	provider.SetDefault("EscapeHTML", codec.ConfigDefault.EscapeHTML)
+}
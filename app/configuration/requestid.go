@@ -0,0 +1,41 @@
+package configuration
+
+import (
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/spf13/viper"
+)
+
+func loadRequestIDConfiguration() (enabled bool, config requestid.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("requestid")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultRequestIDConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return provider.GetBool("Enabled"), config, err
+		}
+	}
+
+	// Unmarshal the configuration file into requestid.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return provider.GetBool("Enabled"), config, err
+}
+
+// Set default configuration for the Request ID Middleware
+func setDefaultRequestIDConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Enabled", true)
+	provider.SetDefault("Header", requestid.ConfigDefault.Header)
+}
@@ -0,0 +1,43 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+)
+
+func loadResponseConfiguration() (config response.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("response")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultResponseConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return config, err
+		}
+	}
+
+	// Unmarshal the configuration file into response.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return config, err
+}
+
+// Set default configuration for the response formats
+func setDefaultResponseConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Formats", response.ConfigDefault.Formats)
+	provider.SetDefault("Errors", response.ConfigDefault.Errors)
+	provider.SetDefault("ProblemTypeBase", response.ConfigDefault.ProblemTypeBase)
+}
@@ -0,0 +1,74 @@
+package configuration
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// UsersConfiguration struct to handle the users resource config.
+type UsersConfiguration struct {
+	// How long soft deleted users stay in the trash before being purged, 0 keeps them forever
+	TrashRetention time.Duration
+	// How often the purge job looks for expired users in the trash
+	PurgeInterval time.Duration
+	// Reject updates without an If-Match header with 428 instead of blindly overwriting
+	RequireIfMatch bool
+	// Page size of the user listing when ?limit= is not given
+	DefaultPageSize int
+	// Biggest page size a client can ask for, bigger limits are capped to it
+	MaxPageSize int
+	// Most items a single bulk request may carry, 0 means no limit
+	MaxBulkSize int
+	// Most rows a single import upload may carry, 0 means no limit
+	MaxImportRows int
+	// Longest an export may stream, a client that stalls holds a database connection until then
+	ExportTimeout time.Duration
+	// Deepest include= a request may ask for, include=a.b is 2 levels deep
+	MaxIncludeDepth int
+}
+
+func loadUsersConfiguration() (UsersConfiguration, error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("users")
+	provider.AddConfigPath("./config")
+
+	// Create a new UsersConfiguration variable
+	var config UsersConfiguration
+
+	// Set default configurations
+	setDefaultUsersConfiguration(provider)
+
+	// Read configuration file
+	err := provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return config, err
+		}
+	}
+
+	// Unmarshal the configuration file into the UsersConfiguration struct
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return config, err
+}
+
+// Set default configuration for the users resource
+func setDefaultUsersConfiguration(provider *viper.Viper) {
+	provider.SetDefault("TrashRetention", "720h")
+	provider.SetDefault("PurgeInterval", "1h")
+	provider.SetDefault("RequireIfMatch", false)
+	provider.SetDefault("DefaultPageSize", 20)
+	provider.SetDefault("MaxPageSize", 100)
+	provider.SetDefault("MaxBulkSize", 500)
+	provider.SetDefault("MaxImportRows", 10000)
+	provider.SetDefault("MaxIncludeDepth", 2)
+	provider.SetDefault("ExportTimeout", "10m")
+}
@@ -0,0 +1,44 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/versioning"
+)
+
+func loadVersioningConfiguration() (config versioning.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("versioning")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultVersioningConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return config, err
+		}
+	}
+
+	// Unmarshal the configuration file into versioning.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return config, err
+}
+
+// Set default configuration for the API versions
+func setDefaultVersioningConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Versions", versioning.ConfigDefault.Versions)
+	provider.SetDefault("Default", versioning.ConfigDefault.Default)
+	provider.SetDefault("Header", versioning.ConfigDefault.Header)
+	provider.SetDefault("MediaType", versioning.ConfigDefault.MediaType)
+}
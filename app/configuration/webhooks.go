@@ -0,0 +1,48 @@
+package configuration
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+)
+
+func loadWebhooksConfiguration() (enabled bool, config webhooks.Config, err error) {
+	// Set a new configuration provider
+	provider := viper.New()
+
+	// Set configuration provider settings
+	provider.SetConfigName("webhooks")
+	provider.AddConfigPath("./config")
+
+	// Set default configurations
+	setDefaultWebhooksConfiguration(provider)
+
+	// Read configuration file
+	err = provider.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Config file not found; ignore error since we have default configurations
+		} else {
+			// Config file was found but another error was produced
+			return provider.GetBool("Enabled"), config, err
+		}
+	}
+
+	// Unmarshal the configuration file into webhooks.Config
+	err = provider.Unmarshal(&config)
+
+	// Return the configuration (and error if occurred)
+	return provider.GetBool("Enabled"), config, err
+}
+
+// Set default configuration for the webhook deliveries
+func setDefaultWebhooksConfiguration(provider *viper.Viper) {
+	provider.SetDefault("Enabled", true)
+	provider.SetDefault("PollInterval", webhooks.ConfigDefault.PollInterval)
+	provider.SetDefault("BatchSize", webhooks.ConfigDefault.BatchSize)
+	provider.SetDefault("Timeout", webhooks.ConfigDefault.Timeout)
+	provider.SetDefault("MaxAttempts", webhooks.ConfigDefault.MaxAttempts)
+	provider.SetDefault("BackoffBase", webhooks.ConfigDefault.BackoffBase)
+	provider.SetDefault("BackoffMax", webhooks.ConfigDefault.BackoffMax)
+	provider.SetDefault("AllowPrivateNetworks", webhooks.ConfigDefault.AllowPrivateNetworks)
+}
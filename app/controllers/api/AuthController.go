@@ -0,0 +1,280 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	"github.com/mikeychowy/fiber-crayplate/database"
+	hashing "github.com/thomasvvugt/fiber-hashing"
+)
+
+type registerRequest struct {
+	Name     string `json:"name" xml:"name" form:"name" validate:"required,max=100"`
+	Email    string `json:"email" xml:"email" form:"email" validate:"required,max=254,email"`
+	Password string `json:"password" xml:"password" form:"password" validate:"required"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email" xml:"email" form:"email" validate:"required,max=254"`
+	Password string `json:"password" xml:"password" form:"password" validate:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" xml:"refresh_token" form:"refresh_token" validate:"required,max=100"`
+}
+
+// loginData is what a login and a refresh answer with, the access token goes in "Authorization: Bearer <access_token>"
+// and the refresh token, good for a single use, gets the next pair from /auth/refresh
+type loginData struct {
+	// AccessToken and RefreshToken are missing while auth is disabled, login only checks the password then
+	AccessToken string `json:",omitempty"`
+	TokenType   string `json:",omitempty"`
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn    int    `json:",omitempty"`
+	RefreshToken string `json:",omitempty"`
+	// User is only in the answer to a login
+	User *userData `json:",omitempty"`
+}
+
+// Shapes of the auth API, exported so the routes can document them
+type (
+	RegisterInput = registerRequest
+	LoginInput    = loginRequest
+	RefreshInput  = refreshRequest
+	LoginResult   = loginData
+	Session       = sessionData
+)
+
+// Register : Create a user with a password, the password has to follow the policy in auth.yaml
+// and only its hash is stored, no response ever carries it
+func Register(c *fiber.Ctx) error {
+	hasher, err := passwordHasher()
+	if err != nil {
+		return err
+	}
+
+	rbod := new(registerRequest)
+	if err := c.BodyParser(rbod); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	// the tag rules and the password policy are reported together, so one round trip shows everything to fix
+	errs := validation.Validate(rbod)
+	if rbod.Password != "" {
+		errs = append(errs, auth.Policy().Check("password", rbod.Password)...)
+	}
+	if len(errs) > 0 {
+		return validation.Failed(errs)
+	}
+
+	hash, err := hasher.CreateHash(rbod.Password)
+	if err != nil {
+		return fmt.Errorf("error hashing the password of a new user: %w", err)
+	}
+	ud, err := insertUser(c.Context(), requestBodyStruct{Name: rbod.Name, Email: rbod.Email}, &hash)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, userETag(ud.Version))
+	return response.Send(c, fiber.StatusCreated, "Welcome aboard, here is your user", ud)
+}
+
+// Login : Check an email and password and issue an access token, a wrong password and an unknown email
+// get the same 401 after the same wait
+func Login(c *fiber.Ctx) error {
+	hasher, err := passwordHasher()
+	if err != nil {
+		return err
+	}
+
+	rbod := new(loginRequest)
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
+	}
+	// no password longer than the policy allows was ever stored, hashing one would only burn the CPU
+	if limit := auth.Policy().MaxLength; limit > 0 && utf8.RuneCountInString(rbod.Password) > limit {
+		return validation.Failed(validation.Errors{{Field: "password", Code: "max", Message: "must be at most " + strconv.Itoa(limit) + " characters long"}})
+	}
+
+	// the lookup goes through lower(email) so it uses the unique index and ignores the case like it does
+	var id int
+	var hash *string
+	err = database.Instance().QueryRow(c.Context(), "SELECT user_id, password_hash FROM users WHERE lower(email)=lower($1) AND deleted_at IS NULL", rbod.Email).Scan(&id, &hash)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("error looking up the user logging in: %w", err)
+	}
+	stored := ""
+	if hash != nil {
+		stored = *hash
+	}
+	ok, err := auth.VerifyPassword(hasher, rbod.Password, stored)
+	if err != nil {
+		return fmt.Errorf("error checking the password of a user logging in: %w", err)
+	}
+	if !ok {
+		return apperrors.New(fiber.StatusUnauthorized, "invalid_credentials", "The email or the password is wrong.")
+	}
+
+	ud, err := findUser(c.Context(), id, userFields)
+	if err != nil {
+		return err
+	}
+	data := loginData{User: &ud}
+	if tokens := providers.AuthProvider(); tokens != nil {
+		sessionID, refreshToken, errS := startSession(c.Context(), ud.UserId, c.Get(fiber.HeaderUserAgent), tokens.RefreshTTL())
+		if errS != nil {
+			return errS
+		}
+		if err := issueTokens(&data, tokens, ud.UserId, sessionID, refreshToken); err != nil {
+			return err
+		}
+	}
+
+	// tokens must not end up in a cache, or in the idempotency store
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return response.Send(c, fiber.StatusOK, "You are logged in", data)
+}
+
+// Refresh : Exchange a refresh token for a new access token and the next refresh token,
+// every refresh token works once and using one twice ends its session
+func Refresh(c *fiber.Ctx) error {
+	tokens, err := tokenProvider()
+	if err != nil {
+		return err
+	}
+	rbod := new(refreshRequest)
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
+	}
+
+	userID, sessionID, next, err := rotateRefreshToken(c.Context(), rbod.RefreshToken, tokens.RefreshTTL())
+	if err != nil {
+		return err
+	}
+	data := loginData{}
+	if err := issueTokens(&data, tokens, userID, sessionID, next); err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return response.Send(c, fiber.StatusOK, "Here are your new tokens", data)
+}
+
+// Logout : End the session of a refresh token, its access tokens stay valid until they expire
+func Logout(c *fiber.Ctx) error {
+	if _, err := tokenProvider(); err != nil {
+		return err
+	}
+	rbod := new(refreshRequest)
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
+	}
+
+	if err := endSessionOf(c.Context(), rbod.RefreshToken); err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusAccepted, "You are logged out", nil)
+}
+
+// GetSessions : Respond the sessions of the user of the access token that are still going
+func GetSessions(c *fiber.Ctx) error {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return err
+	}
+	sessions, err := listSessions(c.Context(), claims.UserID(), claims.SessionID)
+	if err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusOK, "Here are your sessions", sessions)
+}
+
+// DeleteSession : Log out one session of the user of the access token, usually one on another device
+func DeleteSession(c *fiber.Ctx) error {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return err
+	}
+	ended, err := endUserSessions(c.Context(), claims.UserID(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return apperrors.NotFound("session_not_found", fmt.Sprintf("Session %s not found.", c.Params("id")))
+	}
+	return response.Send(c, fiber.StatusAccepted, fmt.Sprintf("Session %s successfuly ended.", c.Params("id")), nil)
+}
+
+// DeleteSessions : Log out every session of the user of the access token, the current one included
+func DeleteSessions(c *fiber.Ctx) error {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return err
+	}
+	ended, err := endUserSessions(c.Context(), claims.UserID(), "")
+	if err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusAccepted, fmt.Sprintf("%d sessions successfuly ended.", ended), nil)
+}
+
+// issueTokens signs the access token of a session and puts it in data next to the refresh token
+func issueTokens(data *loginData, tokens *auth.Tokens, userID int, sessionID, refreshToken string) error {
+	token, _, err := tokens.Issue(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	data.AccessToken, data.TokenType, data.ExpiresIn = token, "Bearer", int(tokens.TTL()/time.Second)
+	data.RefreshToken = refreshToken
+	return nil
+}
+
+// tokenProvider is the auth provider, there are no tokens to refresh or revoke with auth disabled in auth.yaml
+func tokenProvider() (*auth.Tokens, error) {
+	tokens := providers.AuthProvider()
+	if tokens == nil {
+		return nil, apperrors.New(fiber.StatusServiceUnavailable, "tokens_disabled", "Tokens are disabled.")
+	}
+	return tokens, nil
+}
+
+// requestClaims are the claims of the access token of the request, routes that need them are protected
+// but with auth disabled nothing puts them there
+func requestClaims(c *fiber.Ctx) (*auth.Claims, error) {
+	claims := auth.ClaimsFrom(c.Context())
+	if claims == nil {
+		return nil, apperrors.New(fiber.StatusUnauthorized, "missing_token", "This route needs an access token in the Authorization header.")
+	}
+	return claims, nil
+}
+
+// Me : Respond the user the access token of the request was issued to
+func Me(c *fiber.Ctx) error {
+	claims, err := requestClaims(c)
+	if err != nil {
+		return err
+	}
+	ud, err := findUser(c.Context(), claims.UserID(), userFields)
+	if err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusOK, "Here is your user", ud)
+}
+
+// passwordHasher is the hash provider, passwords can't be stored or checked with hashing disabled in hash.yaml
+func passwordHasher() (hashing.Driver, error) {
+	hasher := providers.HashProvider()
+	if hasher == nil {
+		return nil, apperrors.New(fiber.StatusServiceUnavailable, "passwords_disabled", "Passwords need the hash provider, it is disabled.")
+	}
+	return hasher, nil
+}
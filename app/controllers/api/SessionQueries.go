@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+	"github.com/mikeychowy/fiber-crayplate/app/sparse"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// the queries behind the sessions, a session is one login and the family of refresh tokens rotated out of it
+
+type sessionData struct {
+	SessionId  string
+	UserAgent  *string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	// Current is the session of the access token the sessions were asked for with
+	Current bool
+}
+
+// why a session ended before it expired
+const (
+	revokedLogout = "logout"
+	revokedReuse  = "reuse"
+)
+
+// userAgentLimit is how much of the User-Agent a session keeps to tell it apart from the others
+const userAgentLimit = 256
+
+// startSession opens a session for a login and returns its id and its first refresh token
+func startSession(ctx context.Context, userID int, userAgent string, ttl time.Duration) (string, string, error) {
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return "", "", err
+	}
+	token, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	if len(userAgent) > userAgentLimit {
+		userAgent = userAgent[:userAgentLimit]
+	}
+	expires := time.Now().Add(ttl)
+
+	tx, err := database.Instance().Begin(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("error starting the session transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "INSERT INTO sessions(session_id, user_id, user_agent, expires_at) VALUES($1, $2, NULLIF($3, ''), $4)", sessionID, userID, userAgent, expires); err != nil {
+		return "", "", fmt.Errorf("error inserting a new session into database: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO refresh_tokens(token_hash, session_id, expires_at) VALUES($1, $2, $3)", hash, sessionID, expires); err != nil {
+		return "", "", fmt.Errorf("error inserting the refresh token of a new session into database: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", "", fmt.Errorf("error committing the session transaction: %w", err)
+	}
+	return sessionID, token, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for the next one of its session and returns who the session belongs to.
+// A token that was already exchanged ends its whole session: either the client or someone who stole the token
+// is replaying it, and there is no telling which, so neither gets to go on
+func rotateRefreshToken(ctx context.Context, token string, ttl time.Duration) (userID int, sessionID string, next string, err error) {
+	tx, err := database.Instance().Begin(ctx)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("error starting the refresh transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// the rows stay locked until commit, two refreshes with the same token can't both get through
+	hash := auth.HashRefreshToken(token)
+	var expiresAt time.Time
+	var rotatedAt, revokedAt *time.Time
+	var trashed bool
+	err = tx.QueryRow(ctx, `SELECT t.session_id, s.user_id, t.expires_at, t.rotated_at, s.revoked_at, u.deleted_at IS NOT NULL
+		FROM refresh_tokens t JOIN sessions s ON s.session_id = t.session_id JOIN users u ON u.user_id = s.user_id
+		WHERE t.token_hash = $1 FOR UPDATE OF t, s`, hash).Scan(&sessionID, &userID, &expiresAt, &rotatedAt, &revokedAt, &trashed)
+	if err == pgx.ErrNoRows {
+		return 0, "", "", invalidRefreshToken()
+	}
+	if err != nil {
+		return 0, "", "", fmt.Errorf("error looking up a refresh token: %w", err)
+	}
+
+	switch {
+	case revokedAt != nil || trashed:
+		return 0, "", "", invalidRefreshToken()
+	case rotatedAt != nil:
+		if _, err := tx.Exec(ctx, "UPDATE sessions SET revoked_at=now(), revoked_reason=$1 WHERE session_id=$2", revokedReuse, sessionID); err != nil {
+			return 0, "", "", fmt.Errorf("error revoking a session after its refresh token was reused: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, "", "", fmt.Errorf("error committing the refresh transaction: %w", err)
+		}
+		return 0, "", "", apperrors.New(fiber.StatusUnauthorized, "refresh_token_reused", "The refresh token was already used, the session has been ended.")
+	case !expiresAt.After(time.Now()):
+		return 0, "", "", apperrors.New(fiber.StatusUnauthorized, "refresh_token_expired", "The refresh token has expired, log in again.")
+	}
+
+	next, nextHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return 0, "", "", err
+	}
+	expires := time.Now().Add(ttl)
+	if _, err := tx.Exec(ctx, "UPDATE refresh_tokens SET rotated_at=now() WHERE token_hash=$1", hash); err != nil {
+		return 0, "", "", fmt.Errorf("error rotating a refresh token: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO refresh_tokens(token_hash, session_id, expires_at) VALUES($1, $2, $3)", nextHash, sessionID, expires); err != nil {
+		return 0, "", "", fmt.Errorf("error inserting the next refresh token of a session: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE sessions SET last_used_at=now(), expires_at=$1 WHERE session_id=$2", expires, sessionID); err != nil {
+		return 0, "", "", fmt.Errorf("error extending a session: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", "", fmt.Errorf("error committing the refresh transaction: %w", err)
+	}
+	return userID, sessionID, next, nil
+}
+
+// endSessionOf ends the session a refresh token belongs to, rotated tokens of the session included
+func endSessionOf(ctx context.Context, token string) error {
+	tag, err := database.Instance().Exec(ctx, `UPDATE sessions SET revoked_at=COALESCE(revoked_at, now()), revoked_reason=COALESCE(revoked_reason, $1)
+		WHERE session_id=(SELECT session_id FROM refresh_tokens WHERE token_hash=$2)`, revokedLogout, auth.HashRefreshToken(token))
+	if err != nil {
+		return fmt.Errorf("error ending a session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return invalidRefreshToken()
+	}
+	return nil
+}
+
+// endUserSessions ends the sessions of a user that are still going, only the one with sessionID when it is given,
+// and returns how many were ended
+func endUserSessions(ctx context.Context, userID int, sessionID string) (int64, error) {
+	tag, err := database.Instance().Exec(ctx, `UPDATE sessions SET revoked_at=now(), revoked_reason=$1
+		WHERE user_id=$2 AND ($3 = '' OR session_id=$3) AND revoked_at IS NULL AND expires_at > now()`, revokedLogout, userID, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("error ending the sessions of user %d: %w", userID, err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// listSessions reads the sessions of a user that are still going, the most recently used first
+func listSessions(ctx context.Context, userID int, current string) ([]sessionData, error) {
+	rows, err := database.Instance().Query(ctx, `SELECT session_id, user_agent, created_at, last_used_at, expires_at FROM sessions
+		WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > now() ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error returning the sessions of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	sessions := make([]sessionData, 0, 4)
+	for rows.Next() {
+		sd := sessionData{}
+		if errR := rows.Scan(&sd.SessionId, &sd.UserAgent, &sd.CreatedAt, &sd.LastUsedAt, &sd.ExpiresAt); errR != nil {
+			return nil, fmt.Errorf("error scanning the sessions of user %d: %w", userID, errR)
+		}
+		sd.Current = sd.SessionId == current
+		sessions = append(sessions, sd)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error reading the sessions of user %d: %w", userID, rows.Err())
+	}
+	return sessions, nil
+}
+
+// userSessions loads the sessions still going of the users for include=sessions. Only the user of the access token
+// gets theirs, where and when someone logs in is nobody else's business, the other users get a null
+func userSessions(ctx context.Context, keys []interface{}, _ sparse.Include) (map[interface{}]interface{}, error) {
+	related := make(map[interface{}]interface{}, 1)
+	claims := auth.ClaimsFrom(ctx)
+	if claims == nil {
+		return related, nil
+	}
+	for _, key := range keys {
+		if id, ok := key.(int); ok && id == claims.UserID() {
+			sessions, err := listSessions(ctx, id, claims.SessionID)
+			if err != nil {
+				return nil, err
+			}
+			related[key] = sessions
+			break
+		}
+	}
+	return related, nil
+}
+
+// invalidRefreshToken is the answer to a refresh token that is unknown or whose session has ended,
+// the client can't do anything but log in again either way
+func invalidRefreshToken() error {
+	return apperrors.New(fiber.StatusUnauthorized, "invalid_refresh_token", "The refresh token is invalid, log in again.")
+}
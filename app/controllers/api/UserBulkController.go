@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// Modes of a bulk request
+const (
+	// bulkAtomic applies every item or none of them
+	bulkAtomic = "atomic"
+	// bulkBestEffort applies the items that can be applied and reports the others
+	bulkBestEffort = "best_effort"
+)
+
+type bulkCreateRequest struct {
+	Mode  string              `json:"mode" xml:"mode"`
+	Items []requestBodyStruct `json:"items" xml:"items"`
+}
+
+type bulkEditItem struct {
+	UserId int    `json:"user_id" xml:"user_id" validate:"required"`
+	Name   string `json:"name" xml:"name" validate:"required,max=100"`
+	Email  string `json:"email" xml:"email" validate:"required,max=254,email"`
+	// the version the client last saw, 0 skips the check like a missing If-Match
+	Version int `json:"version" xml:"version"`
+}
+
+type bulkEditRequest struct {
+	Mode  string         `json:"mode" xml:"mode"`
+	Items []bulkEditItem `json:"items" xml:"items"`
+}
+
+type bulkDeleteItem struct {
+	UserId  int `json:"user_id" xml:"user_id" validate:"required"`
+	Version int `json:"version" xml:"version"`
+}
+
+type bulkDeleteRequest struct {
+	Mode  string           `json:"mode" xml:"mode"`
+	Items []bulkDeleteItem `json:"items" xml:"items"`
+}
+
+// Shapes of the bulk endpoints, exported so the routes can document them
+type (
+	UserBulkCreate = bulkCreateRequest
+	UserBulkEdit   = bulkEditRequest
+	UserBulkDelete = bulkDeleteRequest
+	UserBulkResult = bulkResult
+)
+
+// bulkResult is the outcome of one item, in the same position as the item in the request
+type bulkResult struct {
+	Index   int
+	Status  int
+	Code    string            `json:",omitempty"`
+	Message string            `json:",omitempty"`
+	Errors  validation.Errors `json:",omitempty"`
+	User    *userData         `json:",omitempty"`
+}
+
+// BulkAddUsers : Add many users at once, {"mode":"atomic|best_effort","items":[{"name":"...","email":"..."}]}
+func BulkAddUsers(c *fiber.Ctx) error {
+	req := new(bulkCreateRequest)
+	if err := c.BodyParser(req); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	if err := checkBulk(req.Mode, len(req.Items)); err != nil {
+		return err
+	}
+
+	return runBulk(c, req.Mode, len(req.Items), fiber.StatusCreated, func(tx pgx.Tx, i int) (bulkResult, error) {
+		item := req.Items[i]
+		if errs := validation.Validate(item); len(errs) > 0 {
+			return bulkResult{}, validation.Failed(errs)
+		}
+
+		ud := userData{}
+		if err := tx.QueryRow(c.Context(), "INSERT INTO users(name, email) VALUES($1, $2) RETURNING "+userColumns, item.Name, item.Email).Scan(ud.fields()...); err != nil {
+			return bulkResult{}, emailTaken(fmt.Errorf("error inserting new user into database: %w", err), item.Email)
+		}
+		if err := webhooks.Enqueue(c.Context(), tx, webhooks.UserCreated, ud); err != nil {
+			return bulkResult{}, err
+		}
+		return bulkResult{Status: fiber.StatusCreated, User: &ud}, nil
+	})
+}
+
+// BulkEditUsers : Edit many users at once, {"mode":"atomic|best_effort","items":[{"user_id":1,"name":"...","email":"...","version":2}]}
+func BulkEditUsers(c *fiber.Ctx) error {
+	req := new(bulkEditRequest)
+	if err := c.BodyParser(req); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	if err := checkBulk(req.Mode, len(req.Items)); err != nil {
+		return err
+	}
+
+	return runBulk(c, req.Mode, len(req.Items), fiber.StatusOK, func(tx pgx.Tx, i int) (bulkResult, error) {
+		item := req.Items[i]
+		if errs := validation.Validate(item); len(errs) > 0 {
+			return bulkResult{}, validation.Failed(errs)
+		}
+
+		// same rule as EditUser, only a version the client has seen gets overwritten
+		ud := userData{}
+		err := tx.QueryRow(c.Context(), "UPDATE users SET name=$1, email=$2, version=version+1 WHERE user_id=$3 AND deleted_at IS NULL AND ($4 = 0 OR version=$4) RETURNING "+userColumns, item.Name, item.Email, item.UserId, item.Version).Scan(ud.fields()...)
+		if err == pgx.ErrNoRows {
+			return bulkResult{}, bulkPreconditionFailed(c.Context(), tx, item.UserId)
+		}
+		if err != nil {
+			return bulkResult{}, emailTaken(fmt.Errorf("error updating specified user into database: %w", err), item.Email)
+		}
+		if err := webhooks.Enqueue(c.Context(), tx, webhooks.UserUpdated, ud); err != nil {
+			return bulkResult{}, err
+		}
+		return bulkResult{Status: fiber.StatusOK, User: &ud}, nil
+	})
+}
+
+// BulkDeleteUsers : Move many users to the trash at once, or remove them for good with ?force=true,
+// {"mode":"atomic|best_effort","items":[{"user_id":1}]}
+func BulkDeleteUsers(c *fiber.Ctx) error {
+	req := new(bulkDeleteRequest)
+	if err := c.BodyParser(req); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	if err := checkBulk(req.Mode, len(req.Items)); err != nil {
+		return err
+	}
+
+	force := c.Query("force") == "true"
+	query := "UPDATE users SET deleted_at=now(), version=version+1 WHERE user_id=$1 AND deleted_at IS NULL AND ($2 = 0 OR version=$2)"
+	if force {
+		query = "DELETE FROM users WHERE user_id=$1 AND ($2 = 0 OR version=$2)"
+	}
+
+	return runBulk(c, req.Mode, len(req.Items), fiber.StatusAccepted, func(tx pgx.Tx, i int) (bulkResult, error) {
+		item := req.Items[i]
+		if errs := validation.Validate(item); len(errs) > 0 {
+			return bulkResult{}, validation.Failed(errs)
+		}
+
+		tag, err := tx.Exec(c.Context(), query, item.UserId, item.Version)
+		if err != nil {
+			return bulkResult{}, fmt.Errorf("error deleting specified user into database: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return bulkResult{}, bulkPreconditionFailed(c.Context(), tx, item.UserId)
+		}
+		if err := webhooks.Enqueue(c.Context(), tx, webhooks.UserDeleted, userDeletedEvent{UserId: item.UserId, Force: force}); err != nil {
+			return bulkResult{}, err
+		}
+		return bulkResult{Status: fiber.StatusAccepted, Message: fmt.Sprintf("User %d successfuly deleted.", item.UserId)}, nil
+	})
+}
+
+// checkBulk validates what every bulk request has in common, the mode and the number of items
+func checkBulk(mode string, count int) error {
+	if mode != "" && mode != bulkAtomic && mode != bulkBestEffort {
+		return validation.Failed(validation.Errors{{Field: "mode", Code: "oneof", Message: "must be one of " + bulkAtomic + ", " + bulkBestEffort}})
+	}
+	if count == 0 {
+		return validation.Failed(validation.Errors{{Field: "items", Code: "required", Message: "is required"}})
+	}
+	if max := providers.GetConfiguration().Users.MaxBulkSize; max > 0 && count > max {
+		return validation.Failed(validation.Errors{{Field: "items", Code: "max", Message: fmt.Sprintf("must be at most %d items", max)}})
+	}
+	return nil
+}
+
+// runBulk applies every item inside a single transaction, each one in its own savepoint
+// so a failed item can be undone alone in best effort mode, atomic mode gives up at the first failure.
+// Item failures are reported per item, anything the server is to blame for aborts the whole request.
+func runBulk(c *fiber.Ctx, mode string, count int, okStatus int, apply func(tx pgx.Tx, i int) (bulkResult, error)) error {
+	if mode == "" {
+		mode = bulkAtomic
+	}
+
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	db := database.Instance()
+	tx, err := db.Begin(c.Context())
+	if err != nil {
+		return fmt.Errorf("error starting the bulk transaction: %w", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	results := make([]bulkResult, 0, count)
+	failed := 0
+	for i := 0; i < count; i++ {
+		// a nested Begin is a savepoint in pgx
+		savepoint, err := tx.Begin(c.Context())
+		if err != nil {
+			return fmt.Errorf("error creating the savepoint of item %d: %w", i, err)
+		}
+
+		result, err := apply(savepoint, i)
+		if err != nil {
+			appErr := apperrors.From(err)
+			if appErr.Status >= fiber.StatusInternalServerError {
+				return err
+			}
+			if errR := savepoint.Rollback(c.Context()); errR != nil {
+				return fmt.Errorf("error rolling back item %d: %w", i, errR)
+			}
+			result = bulkResult{Status: appErr.Status, Code: appErr.Code, Message: appErr.Message}
+			if errs, ok := appErr.Details.(validation.Errors); ok {
+				result.Errors = errs
+			}
+			failed++
+		} else if err := savepoint.Commit(c.Context()); err != nil {
+			return fmt.Errorf("error releasing the savepoint of item %d: %w", i, err)
+		}
+		result.Index = i
+		results = append(results, result)
+
+		if failed > 0 && mode == bulkAtomic {
+			break
+		}
+	}
+
+	if failed > 0 && mode == bulkAtomic {
+		// nothing was kept, tell the client which items were undone because of the failed one
+		for i := range results {
+			if results[i].Status < fiber.StatusBadRequest {
+				results[i] = bulkResult{Index: i, Status: fiber.StatusFailedDependency, Code: "rolled_back", Message: "Rolled back because another item failed."}
+			}
+		}
+		for i := len(results); i < count; i++ {
+			results = append(results, bulkResult{Index: i, Status: fiber.StatusFailedDependency, Code: "not_attempted", Message: "Not attempted because another item failed."})
+		}
+		return response.Send(c, fiber.StatusUnprocessableEntity, "No item was applied, at least one of them failed", results, response.WithCode("bulk_failed"))
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return fmt.Errorf("error committing the bulk transaction: %w", err)
+	}
+
+	if failed > 0 {
+		return response.Send(c, fiber.StatusMultiStatus, fmt.Sprintf("%d of %d items were applied", count-failed, count), results,
+			response.WithMeta("succeeded", count-failed), response.WithMeta("failed", failed))
+	}
+	return response.Send(c, okStatus, fmt.Sprintf("All %d items were applied", count), results,
+		response.WithMeta("succeeded", count), response.WithMeta("failed", 0))
+}
+
+// bulkPreconditionFailed tells a missing user apart from one at another version than the item says
+func bulkPreconditionFailed(ctx context.Context, tx pgx.Tx, userID int) error {
+	var version int
+	err := tx.QueryRow(ctx, "SELECT version FROM users WHERE user_id=$1 AND deleted_at IS NULL", userID).Scan(&version)
+	if err == pgx.ErrNoRows {
+		return apperrors.NotFound("user_not_found", fmt.Sprintf("User %d not found.", userID))
+	}
+	if err != nil {
+		return fmt.Errorf("error checking the version of specified user: %w", err)
+	}
+	return apperrors.New(fiber.StatusPreconditionFailed, "precondition_failed", fmt.Sprintf("User %d is at version %d.", userID, version))
+}
@@ -2,330 +2,580 @@ package api
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	jsoniter "github.com/json-iterator/go"
-	"github.com/json-iterator/go/extra"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/httpcache"
+	"github.com/mikeychowy/fiber-crayplate/app/listing"
+	"github.com/mikeychowy/fiber-crayplate/app/pagination"
+	"github.com/mikeychowy/fiber-crayplate/app/patch"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/sparse"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
 	"github.com/mikeychowy/fiber-crayplate/database"
 )
 
 type userData struct {
 	UserId int
 	Name   string
+	// Email is null for the users created before it was required
+	Email     *string
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time `json:",omitempty"`
 }
 
-type responseStruct struct {
-	Success bool
-	Status  int
-	Message string
-	Data    []userData
+// userFields are the fields of a user fields= can pick from, named like their columns
+var userFields = []string{"user_id", "name", "email", "version", "created_at", "updated_at"}
+
+// userColumns are the columns behind userData, in the order fields scans them
+var userColumns = strings.Join(userFields, ", ")
+
+// fields are the scan destinations of userColumns
+func (ud *userData) fields() []interface{} {
+	return ud.fieldsOf(userFields)
+}
+
+// fieldsOf are the scan destinations of some of the userFields
+func (ud *userData) fieldsOf(names []string) []interface{} {
+	dest := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		dest = append(dest, ud.field(name))
+	}
+	return dest
+}
+
+func (ud *userData) field(name string) interface{} {
+	switch name {
+	case "user_id":
+		return &ud.UserId
+	case "name":
+		return &ud.Name
+	case "email":
+		return &ud.Email
+	case "version":
+		return &ud.Version
+	case "created_at":
+		return &ud.CreatedAt
+	case "updated_at":
+		return &ud.UpdatedAt
+	}
+	panic("unknown user field " + name)
 }
 
 type requestBodyStruct struct {
-	Name string `json:"name" xml:"name" form:"name"`
+	Name  string `json:"name" xml:"name" form:"name" validate:"required,max=100"`
+	Email string `json:"email" xml:"email" form:"email" validate:"required,max=254,email"`
 }
 
-// GetAllUsers : Respond all users as JSON
-func GetAllUsers(c *fiber.Ctx) error {
+// User and UserInput are the shapes of the users API, exported so the routes can document them
+type (
+	User      = userData
+	UserInput = requestBodyStruct
+)
 
-	// naming strategy for jsoniter so we don't have to add json tags individually
-	extra.SetNamingStrategy(extra.LowerCaseWithUnderscores)
+// UserListing is what GET /users can be filtered, sorted and searched by
+var UserListing = listing.Schema{
+	Fields: map[string]listing.Field{
+		"user_id":    {Column: "user_id", Type: listing.Int, Operators: []string{"eq", "ne", "gt", "gte", "lt", "lte", "in"}, Sortable: true},
+		"name":       {Column: "name", Type: listing.String, Operators: []string{"eq", "ne", "contains", "starts_with", "in"}, Sortable: true},
+		"email":      {Column: "email", Type: listing.String, Operators: []string{"eq", "ne", "contains", "starts_with", "in"}, CaseInsensitive: true},
+		"created_at": {Column: "created_at", Type: listing.Time, Operators: []string{"gt", "gte", "lt", "lte"}, Sortable: true},
+		"updated_at": {Column: "updated_at", Type: listing.Time, Operators: []string{"gt", "gte", "lt", "lte"}, Sortable: true},
+	},
+	Search: []string{"name", "email"},
+	Key:    "user_id",
+	Params: []string{"fields", "include"},
+}
 
-	// this is the slice to hold "data:[]" part of the response
-	dataSlice := make([]userData, 0, 1000)
+// UserFieldset is what fields= and include= accept on GET /users and GET /users/:id,
+// include=sessions embeds the sessions still going of the user of the access token
+var UserFieldset = sparse.Schema{
+	Fields: userFields,
+	Relations: map[string]sparse.Relation{
+		"sessions": {Load: userSessions},
+	},
+}
 
-	// not exactly the db instance, it's the pool instance
-	// but for all intents and purposes works exactly like db connection
-	// here we query all rows from the query string and sort them by user id
-	db := database.Instance()
-	rows, err := db.Query(c.Context(), "SELECT user_id, name FROM users ORDER BY user_id ASC")
+// GetAllUsers : Respond a page of users as JSON, ?limit= sets the page size and ?cursor= picks up after a previous page,
+// filter with name[contains]=x or user_id[gt]=10, sort with sort=-name,user_id and search with q=,
+// fields=user_id,name leaves the other fields out and include= embeds related resources
+func GetAllUsers(c *fiber.Ctx) error {
+	start := time.Now()
 
-	// pool error handling
+	// read the page size and where the previous page stopped
+	config := providers.GetConfiguration().Users
+	page, err := pagination.FromRequest(c, config.DefaultPageSize, config.MaxPageSize)
 	if err != nil {
-		panic(fmt.Sprintf("Error returning all users from database: %s", err))
+		return err
 	}
 
-	// so we don't forget to close the rows downstairs
-	defer rows.Close()
+	// read the filters, sort and search, only what UserListing whitelists gets through
+	lq, err := listing.Parse(c, UserListing)
+	if err != nil {
+		return err
+	}
+	sel, err := sparse.Parse(c, UserFieldset, config.MaxIncludeDepth)
+	if err != nil {
+		return err
+	}
 
-	// rows error handler
-	if rows.Err() != nil {
-		panic(fmt.Sprintf("Error reading all users rows from database: %s", rows.Err()))
+	// the cursor is made of the sort fields and the ETag of the versions, they are read even when they don't go out
+	read := []string{"user_id", "version"}
+	for _, s := range lq.Sorts {
+		read = append(read, s.Field)
+	}
+	users, hasMore, nextCursor, err := listUsers(c.Context(), lq, page, userReadFields(sel, read...))
+	if err != nil {
+		return err
 	}
 
-	// my standard struct for a response
-	rs := responseStruct{
-		Success: true,
-		Status:  200,
-		Message: "Here is all the users we have",
-		Data:    dataSlice,
+	// the page is the same as long as the same users at the same versions are on it,
+	// included resources have their own versions so pages with include= are always sent in full
+	if len(users) > 0 && len(sel.Include) == 0 && httpcache.NotModified(c, usersETag(c, users, hasMore), time.Time{}) {
+		return nil
 	}
-	// create the user data holder struct, to describe how i would like the json data insides to be like
-	ud := userData{
-		UserId: 0,
-		Name:   "",
+	var dataSlice interface{} = users
+	if !sel.All() {
+		if dataSlice, err = userRows(c, sel, users); err != nil {
+			return err
+		}
 	}
-	// holders for ids and names
-	var id int
-	var name string
 
-	// change response Content-Type header to application/json
-	c.Type("json")
+	// check for 404, only when nothing narrowed the listing down
+	if len(users) <= 0 && len(page.After) == 0 && len(lq.Filters) == 0 && lq.Search == "" {
+		return response.Send(c, fiber.StatusNotFound, "We can't find any users, create some first", dataSlice)
+	}
 
-	// iterate through all rows returned from db
-	for rows.Next() {
-		// like i said, holders
-		errR := rows.Scan(&id, &name)
+	meta, links := pagination.Build(c, page, hasMore, nextCursor)
 
-		// scanner error handling
-		if errR != nil {
-			panic(fmt.Sprintf("Error Scanning result set of users: %s", errR))
-		}
+	return response.Send(c, fiber.StatusOK, "Here are all the users", dataSlice, response.WithPage(meta, links), response.WithTiming(start))
+}
 
-		// pass to holder struct
-		ud.UserId, ud.Name = id, name
+// GetUser : Respond a single user by id as JSON, with fields= and include= like the listing
+func GetUser(c *fiber.Ctx) error {
+	// get the request parameter of user id
+	queryID, err := userID(c.Params("id"))
+	if err != nil {
+		return err
+	}
 
-		// append to data slice
-		dataSlice = append(dataSlice, ud)
+	sel, err := sparse.Parse(c, UserFieldset, providers.GetConfiguration().Users.MaxIncludeDepth)
+	if err != nil {
+		return err
 	}
 
-	if len(dataSlice) <= 0 {
-		// check for 404
+	// the version and the last change are read for the validators whatever fields= says
+	ud, err := findUser(c.Context(), queryID, userReadFields(sel, "user_id", "version", "updated_at"))
+	if err != nil {
+		return err
+	}
 
-		rs.Success, rs.Status, rs.Message, rs.Data = false, 404, "We can't find any users, create some first", dataSlice
-		c.Status(rs.Status)
+	// the ETag lets clients send the version back in If-Match when they edit, and in If-None-Match when they read,
+	// an included resource can change without the user changing so those reads are always sent in full
+	etag := userReadETag(c, ud.Version, sel)
+	if len(sel.Include) > 0 {
+		c.Set(fiber.HeaderETag, etag)
+	} else if httpcache.NotModified(c, etag, ud.UpdatedAt) {
+		return nil
+	}
 
-		// marshal response struct to json
-		output, errJ := jsoniter.Marshal(rs)
-		if errJ != nil {
-			panic(fmt.Sprintf("Error converting all users to json: %s", errJ))
+	var data interface{} = ud
+	if !sel.All() {
+		rows, errR := userRows(c, sel, []userData{ud})
+		if errR != nil {
+			return errR
 		}
-		return c.Send(output)
+		data = rows[0]
 	}
-	// success is here
+	return response.Send(c, fiber.StatusOK, "Here is the specified user", data)
+}
 
-	rs.Success, rs.Status, rs.Message, rs.Data = true, 200, "Here are all the users", dataSlice
-	c.Status(rs.Status)
+// AddUser : Add a single user to the database
+func AddUser(c *fiber.Ctx) error {
+	rbod := new(requestBodyStruct)
 
-	// marshal response struct to json
-	output, errJ := jsoniter.Marshal(rs)
-	if errJ != nil {
-		panic(fmt.Sprintf("Error converting all users to json: %s", errJ))
+	// parse the body, pass values to the struct and check them against its rules
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
 	}
-	strOut := string(output)
-	return c.SendString(strOut)
-}
 
-// GetUser : Respond a single user by id as JSON
-func GetUser(c *fiber.Ctx) error {
+	ud, err := insertUser(c.Context(), *rbod, nil)
+	if err != nil {
+		return err
+	}
 
-	// naming strategy for jsoniter so we don't have to add json tags individually
-	extra.SetNamingStrategy(extra.LowerCaseWithUnderscores)
+	c.Set(fiber.HeaderETag, userETag(ud.Version))
+	return response.Send(c, fiber.StatusCreated, "Here is the new user", ud)
+}
 
-	// this is the slice to hold "data:[]" part of the response
-	dataSlice := make([]userData, 0, 10)
+// EditUser : Edit a single user
+func EditUser(c *fiber.Ctx) error {
+	rbod := new(requestBodyStruct)
 
-	// my standard struct for a response
-	rs := responseStruct{
-		Success: true,
-		Status:  200,
-		Message: "Here is all the users we have",
-		Data:    dataSlice,
-	}
-	// create the user data holder struct, to describe how i would like the json data insides to be like
-	ud := userData{
-		UserId: 0,
-		Name:   "",
+	// parse the body, pass values to the struct and check them against its rules
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
 	}
 
 	// get the request parameter of user id
-	queryID := c.Params("id")
-
-	// not exactly the db instance, it's the pool instance
-	// but for all intents and purposes works exactly like db connection
-	// here we query a row from the query
-	db := database.Instance()
-	err := db.QueryRow(c.Context(), "SELECT user_id, name FROM users WHERE user_id=$1", queryID).Scan(&ud.UserId, &ud.Name)
-
-	// scanner error handling
+	queryID, err := userID(c.Params("id"))
 	if err != nil {
-		panic(fmt.Sprintf("Error returning user with id %s from the database: %s", queryID, err))
+		return err
 	}
 
-	// append the user data to the slice
-	dataSlice = append(dataSlice, ud)
-
-	// set the status, and header of content-type
-	// also set the response message and data
-	c.Status(rs.Status)
-	c.Type("json")
-	rs.Message, rs.Data = "Here is the specified user", dataSlice
+	// work out which versions the client is allowed to overwrite
+	versions, anyVersion, err := userPrecondition(c)
+	if err != nil {
+		return err
+	}
 
-	output, errJ := jsoniter.Marshal(rs)
-	if errJ != nil {
-		panic(fmt.Sprintf("Error converting the specified user to json: %s", errJ))
+	ud, err := updateUser(c.Context(), queryID, *rbod, versions, anyVersion)
+	if err == pgx.ErrNoRows {
+		return userPreconditionFailed(c, queryID)
+	}
+	if err != nil {
+		return err
 	}
 
-	return c.Send(output)
+	c.Set(fiber.HeaderETag, userETag(ud.Version))
+	return response.Send(c, fiber.StatusOK, "Here is the updated user", ud)
 }
 
-// AddUser : Add a single user to the database
-func AddUser(c *fiber.Ctx) error {
-	rbod := new(requestBodyStruct)
-
-	// parse the body and pass values to the struct
-	if err := c.BodyParser(rbod); err != nil {
-		panic(fmt.Sprintf("error parsing body in create new user: %s", err))
+// PatchUser : Edit only some fields of a single user, with a JSON Merge Patch (application/merge-patch+json)
+// or a JSON Patch (application/json-patch+json)
+func PatchUser(c *fiber.Ctx) error {
+	// advertise what we understand, it helps clients that got a 415
+	c.Set("Accept-Patch", patch.AcceptPatch)
+	if _, err := patch.MediaType(c.Get(fiber.HeaderContentType)); err != nil {
+		return err
 	}
 
-	// this is the slice to hold "data:[]" part of the response
-	dataSlice := make([]userData, 0, 1)
-
-	// my standard struct for a response
-	rs := responseStruct{
-		Success: true,
-		Status:  201,
-		Message: "Here is all the users we have",
-		Data:    dataSlice,
+	// get the request parameter of user id
+	queryID, err := userID(c.Params("id"))
+	if err != nil {
+		return err
 	}
-	// create the user data holder struct, to describe how i would like the json data insides to be like
-	ud := userData{
-		UserId: 0,
-		Name:   "",
+
+	// work out which versions the client is allowed to patch
+	versions, anyVersion, err := userPrecondition(c)
+	if err != nil {
+		return err
 	}
 
-	// not exactly the db instance, it's the pool instance
-	// but for all intents and purposes works exactly like db connection
-	// here we execute the insert
+	// the patch is applied to the row as it is now, lock it so nobody changes it in between
 	db := database.Instance()
-	if _, err := db.Exec(c.Context(), "INSERT INTO users(name) VALUES($1)", rbod.Name); err != nil {
-		panic(fmt.Sprintf("error inserting new user into database: %s", err))
+	tx, err := db.Begin(c.Context())
+	if err != nil {
+		return fmt.Errorf("error starting the patch of specified user: %w", err)
 	}
+	defer tx.Rollback(c.Context())
 
-	// query the new user to double check
-	if err := db.QueryRow(c.Context(), "SELECT user_id, name FROM users WHERE name=$1", rbod.Name).Scan(&ud.UserId, &ud.Name); err != nil {
-		panic(fmt.Sprintf("error in getting the new user from database: %s", err))
+	ud := userData{}
+	err = tx.QueryRow(c.Context(), "SELECT "+userColumns+" FROM users WHERE user_id=$1 AND deleted_at IS NULL FOR UPDATE", queryID).Scan(ud.fields()...)
+	if err == pgx.ErrNoRows {
+		return apperrors.NotFound("user_not_found", fmt.Sprintf("User %d not found.", queryID))
+	}
+	if err != nil {
+		return fmt.Errorf("error getting specified user to patch: %w", err)
+	}
+	if !anyVersion && !containsVersion(versions, ud.Version) {
+		c.Set(fiber.HeaderETag, userETag(ud.Version))
+		return apperrors.New(fiber.StatusPreconditionFailed, "precondition_failed", fmt.Sprintf("User %d has been modified since it was fetched.", queryID))
 	}
 
-	// append the user data to the slice
-	dataSlice = append(dataSlice, ud)
-
-	// set the status, and header of content-type
-	// also set the response message and data
-	c.Status(rs.Status)
-	c.Type("json")
-	rs.Message, rs.Data = "Here is the new user", dataSlice
-
-	// naming strategy for jsoniter so we don't have to add json tags individually
-	extra.SetNamingStrategy(extra.LowerCaseWithUnderscores)
+	// patch the editable fields only, then check the result like any other request body
+	current, err := codec.Marshal(userBody(ud))
+	if err != nil {
+		return fmt.Errorf("error converting specified user to a patchable document: %w", err)
+	}
+	patched, err := patch.Apply(c.Get(fiber.HeaderContentType), current, c.Body())
+	if err != nil {
+		return err
+	}
+	rbod := new(requestBodyStruct)
+	if err := patch.Decode(patched, rbod); err != nil {
+		return err
+	}
+	if err := validation.Check(rbod); err != nil {
+		return err
+	}
 
-	output, errJ := jsoniter.Marshal(rs)
-	if errJ != nil {
-		panic(fmt.Sprintf("Error converting the new user to json: %s", errJ))
+	err = tx.QueryRow(c.Context(), "UPDATE users SET name=$1, email=$2, version=version+1 WHERE user_id=$3 RETURNING "+userColumns, rbod.Name, rbod.Email, ud.UserId).Scan(ud.fields()...)
+	if err != nil {
+		return emailTaken(fmt.Errorf("error patching specified user into database: %w", err), rbod.Email)
+	}
+	if err := webhooks.Enqueue(c.Context(), tx, webhooks.UserUpdated, ud); err != nil {
+		return err
+	}
+	if err := tx.Commit(c.Context()); err != nil {
+		return fmt.Errorf("error committing the patch of specified user: %w", err)
 	}
 
-	return c.Send(output)
+	c.Set(fiber.HeaderETag, userETag(ud.Version))
+	return response.Send(c, fiber.StatusOK, "Here is the patched user", ud)
 }
 
-// EditUser : Edit a single user
-func EditUser(c *fiber.Ctx) error {
-	rbod := new(requestBodyStruct)
+// DeleteUser : Move a single user to the trash, or remove it for good with ?force=true
+func DeleteUser(c *fiber.Ctx) error {
+	// get the request parameter of user id
+	queryID, err := userID(c.Params("id"))
+	if err != nil {
+		return err
+	}
 
-	// parse the body and pass values to the struct
-	if err := c.BodyParser(rbod); err != nil {
-		panic(fmt.Sprintf("error parsing body in edit user: %s", err))
+	force := c.Query("force") == "true"
+	if err := deleteUser(c.Context(), queryID, force); err != nil {
+		return err
 	}
 
-	// this is the slice to hold "data:[]" part of the response
-	dataSlice := make([]userData, 0, 1)
+	if force {
+		return response.Send(c, fiber.StatusAccepted, fmt.Sprintf("User %d successfuly deleted.", queryID), nil)
+	}
+	return response.Send(c, fiber.StatusAccepted, fmt.Sprintf("User %d successfuly moved to the trash.", queryID), nil)
+}
 
-	// my standard struct for a response
-	rs := responseStruct{
-		Success: true,
-		Status:  200,
-		Message: "Here is all the users we have",
-		Data:    dataSlice,
+// GetTrashedUsers : Respond the soft deleted users as JSON, most recently deleted first, ?limit= and ?cursor=
+// page through them like the users listing
+func GetTrashedUsers(c *fiber.Ctx) error {
+	// read the page size and where the previous page stopped
+	config := providers.GetConfiguration().Users
+	page, err := pagination.FromRequest(c, config.DefaultPageSize, config.MaxPageSize)
+	if err != nil {
+		return err
 	}
-	// create the user data holder struct, to describe how i would like the json data insides to be like
-	ud := userData{
-		UserId: 0,
-		Name:   "",
+
+	sql := "SELECT " + userColumns + ", deleted_at FROM users WHERE deleted_at IS NOT NULL"
+	args := make([]interface{}, 0, 3)
+	// the cursor holds the deletion time and the id of the last user of the previous page,
+	// the id breaks the ties between users deleted in the same instant
+	if len(page.After) > 0 {
+		if len(page.After) != 2 {
+			return apperrors.BadRequest("invalid_cursor", "cursor does not belong to this listing")
+		}
+		deletedAt, errT := time.Parse(time.RFC3339Nano, fmt.Sprint(page.After[0]))
+		afterID, errI := strconv.Atoi(fmt.Sprint(page.After[1]))
+		if errT != nil || errI != nil {
+			return apperrors.BadRequest("invalid_cursor", "cursor does not belong to this listing")
+		}
+		args = append(args, deletedAt, afterID)
+		sql += " AND (deleted_at < $1 OR (deleted_at = $1 AND user_id > $2))"
 	}
+	args = append(args, page.Limit+1)
+	sql += " ORDER BY deleted_at DESC, user_id ASC LIMIT $" + strconv.Itoa(len(args))
 
-	// get the request parameter of user id
-	queryID := c.Params("id")
+	// this is the slice to hold "data:[]" part of the response
+	dataSlice := make([]userData, 0, page.Limit+1)
 
 	// not exactly the db instance, it's the pool instance
 	// but for all intents and purposes works exactly like db connection
-	// here we execute the update
+	// here we query the trashed rows, most recently deleted first, and one extra to know if there is a next page
 	db := database.Instance()
-	if _, err := db.Exec(c.Context(), "UPDATE users SET name=$1 WHERE user_id=$2", rbod.Name, queryID); err != nil {
-		panic(fmt.Sprintf("error updating specified user into database: %s", err))
+	rows, err := db.Query(c.Context(), sql, args...)
+
+	// pool error handling
+	if err != nil {
+		return fmt.Errorf("Error returning trashed users from database: %w", err)
+	}
+
+	// so we don't forget to close the rows downstairs
+	defer rows.Close()
+
+	// iterate through all rows returned from db
+	for rows.Next() {
+		ud := userData{}
+		if errR := rows.Scan(append(ud.fields(), &ud.DeletedAt)...); errR != nil {
+			return fmt.Errorf("Error Scanning result set of trashed users: %w", errR)
+		}
+		dataSlice = append(dataSlice, ud)
+	}
+
+	// rows error handler
+	if rows.Err() != nil {
+		return fmt.Errorf("Error reading trashed users rows from database: %w", rows.Err())
 	}
 
-	// query the updated user to double check
-	if err := db.QueryRow(c.Context(), "SELECT user_id, name FROM users WHERE user_id=$1", queryID).Scan(&ud.UserId, &ud.Name); err != nil {
-		panic(fmt.Sprintf("error in getting the updated user from database: %s", err))
+	// the extra row only tells us there is more, it belongs to the next page
+	hasMore := len(dataSlice) > page.Limit
+	if hasMore {
+		dataSlice = dataSlice[:page.Limit]
 	}
+	nextCursor := ""
+	if len(dataSlice) > 0 {
+		last := dataSlice[len(dataSlice)-1]
+		nextCursor = pagination.EncodeCursor(last.DeletedAt.Format(time.RFC3339Nano), last.UserId)
+	}
+	meta, links := pagination.Build(c, page, hasMore, nextCursor)
 
-	// append the user data to the slice
-	dataSlice = append(dataSlice, ud)
+	if len(dataSlice) <= 0 && len(page.After) == 0 {
+		return response.Send(c, fiber.StatusOK, "The trash is empty", dataSlice, response.WithPage(meta, links))
+	}
+	return response.Send(c, fiber.StatusOK, "Here are all the users in the trash", dataSlice, response.WithPage(meta, links))
+}
 
-	// set the status, and header of content-type
-	// also set the response message and data
-	c.Status(rs.Status)
-	c.Type("json")
-	rs.Message, rs.Data = "Here is the updated user", dataSlice
+// RestoreUser : Bring a single user back from the trash
+func RestoreUser(c *fiber.Ctx) error {
+	// create the user data holder struct, to describe how i would like the json data insides to be like
+	ud := userData{}
 
-	// naming strategy for jsoniter so we don't have to add json tags individually
-	extra.SetNamingStrategy(extra.LowerCaseWithUnderscores)
+	// get the request parameter of user id
+	queryID, err := userID(c.Params("id"))
+	if err != nil {
+		return err
+	}
 
-	output, errJ := jsoniter.Marshal(rs)
-	if errJ != nil {
-		panic(fmt.Sprintf("Error converting the updated user to json: %s", errJ))
+	// here we clear the deleted mark and read the restored user back
+	err = inUserTx(c.Context(), func(tx pgx.Tx) error {
+		err := tx.QueryRow(c.Context(), "UPDATE users SET deleted_at=NULL, version=version+1 WHERE user_id=$1 AND deleted_at IS NOT NULL RETURNING "+userColumns, queryID).Scan(ud.fields()...)
+		if err == pgx.ErrNoRows {
+			return apperrors.NotFound("user_not_in_trash", fmt.Sprintf("User %d is not in the trash.", queryID))
+		}
+		if err != nil {
+			return fmt.Errorf("error restoring specified user from the trash: %w", err)
+		}
+		return webhooks.Enqueue(c.Context(), tx, webhooks.UserRestored, ud)
+	})
+	if err != nil {
+		return err
 	}
 
-	return c.Send(output)
+	c.Set(fiber.HeaderETag, userETag(ud.Version))
+	return response.Send(c, fiber.StatusOK, "Here is the restored user", ud)
 }
 
-// DeleteUser : Delete a single user
-func DeleteUser(c *fiber.Ctx) error {
+// userReadFields are the fields to read for a selection, the ones that go out and the ones the handler needs
+func userReadFields(sel sparse.Selection, needed ...string) []string {
+	names := make([]string, 0, len(userFields))
+	for _, name := range userFields {
+		if sel.Wants(name) || containsField(needed, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
-	// this is the slice to hold "data:[]" part of the response
-	dataSlice := make([]userData, 0, 1)
+func containsField(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
 
-	// my standard struct for a response
-	rs := responseStruct{
-		Success: true,
-		Status:  202,
-		Message: "Here is all the users we have",
-		Data:    dataSlice,
+// userRows shapes users the way fields= and include= asked, only the fields asked for and the included relations
+func userRows(c *fiber.Ctx, sel sparse.Selection, users []userData) ([]map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, 0, len(users))
+	keys := make([]interface{}, 0, len(users))
+	for i := range users {
+		row := make(map[string]interface{}, len(userFields)+len(sel.Include))
+		for _, name := range userFields {
+			if sel.Wants(name) {
+				// named like the fields of userData would be
+				row[codec.Key(name)] = reflect.ValueOf(users[i].field(name)).Elem().Interface()
+			}
+		}
+		rows = append(rows, row)
+		keys = append(keys, users[i].UserId)
+	}
+	if err := sel.Embed(c.Context(), rows, keys); err != nil {
+		return nil, err
 	}
+	return rows, nil
+}
 
-	// get the request parameter of user id
-	queryID := c.Params("id")
+// userBody is the editable part of a user, what a PUT would have to send to leave it as it is
+func userBody(ud userData) requestBodyStruct {
+	body := requestBodyStruct{Name: ud.Name}
+	if ud.Email != nil {
+		body.Email = *ud.Email
+	}
+	return body
+}
 
-	// not exactly the db instance, it's the pool instance
-	// but for all intents and purposes works exactly like db connection
-	// here we execute the update
-	db := database.Instance()
-	if _, err := db.Exec(c.Context(), "DELETE FROM users WHERE user_id=$1", queryID); err != nil {
-		panic(fmt.Sprintf("error deleting specified user into database: %s", err))
+// userETag builds the strong ETag of a user out of its row version
+func userETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// userReadETag is the ETag of a read of the user. The strong version tag only goes with the full JSON user,
+// the representation If-Match stands for, fields=, include= and the other formats are different bytes of the same version
+// and get a weak tag of their own that If-Match ignores. Send adds Vary: Accept since the tag follows the format
+func userReadETag(c *fiber.Ctx, version int, sel sparse.Selection) string {
+	format, _ := response.Negotiate(c, false)
+	if sel.All() && format == response.JSON {
+		return userETag(version)
 	}
+	return httpcache.WeakETag(strconv.Itoa(version), strings.Join(sel.Fields, ","), c.Query("include"), format)
+}
+
+// usersETag builds the weak ETag of a page of users out of the request and the versions of its users
+func usersETag(c *fiber.Ctx, users []userData, hasMore bool) string {
+	// the format is in too, the same page in CSV or in JSON are different bytes
+	format, _ := response.Negotiate(c, true)
+	parts := make([]string, 0, len(users)+3)
+	parts = append(parts, string(c.Request().URI().QueryString()), format, strconv.FormatBool(hasMore))
+	for _, ud := range users {
+		parts = append(parts, strconv.Itoa(ud.UserId)+":"+strconv.Itoa(ud.Version))
+	}
+	return httpcache.WeakETag(parts...)
+}
 
-	// set the status, and header of content-type
-	// also set the response message and data
-	c.Status(rs.Status)
-	c.Type("json")
-	rs.Message = fmt.Sprintf("User %s successfuly deleted.", queryID)
+// userPrecondition reads If-Match and returns the versions the client expects the user to be at,
+// anyVersion is true when there is nothing to check against (no header, or If-Match: *)
+func userPrecondition(c *fiber.Ctx) (versions []int, anyVersion bool, err error) {
+	ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if ifMatch == "" {
+		// in strict mode blind overwrites are not allowed at all
+		if config := providers.GetConfiguration(); config != nil && config.Users.RequireIfMatch {
+			return nil, false, apperrors.New(fiber.StatusPreconditionRequired, "precondition_required", "This request requires an If-Match header with the ETag of the user.")
+		}
+		return nil, true, nil
+	}
+	if ifMatch == "*" {
+		return nil, true, nil
+	}
 
-	// naming strategy for jsoniter so we don't have to add json tags individually
-	extra.SetNamingStrategy(extra.LowerCaseWithUnderscores)
+	versions = make([]int, 0, 1)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses the strong comparison, weak tags can never match
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, errV := strconv.Atoi(tag[1 : len(tag)-1]); errV == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, false, nil
+}
 
-	output, errJ := jsoniter.Marshal(rs)
-	if errJ != nil {
-		panic(fmt.Sprintf("Error converting the deleted user to json: %s", errJ))
+// containsVersion is true when the client's If-Match names the version
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
 	}
+	return false
+}
 
-	return c.Send(output)
+// userPreconditionFailed tells a missing user apart from one that moved on to another version
+func userPreconditionFailed(c *fiber.Ctx, queryID int) error {
+	version, err := userVersion(c.Context(), queryID)
+	if err != nil {
+		return err
+	}
+	// hand out the current ETag so the client can refetch and retry
+	c.Set(fiber.HeaderETag, userETag(version))
+	return apperrors.New(fiber.StatusPreconditionFailed, "precondition_failed", fmt.Sprintf("User %d has been modified since it was fetched.", queryID))
 }
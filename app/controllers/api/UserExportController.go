@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/listing"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// Media types of the export formats
+const (
+	mimeNDJSON = "application/x-ndjson"
+	mimeCSV    = "text/csv"
+)
+
+// exportFlushEvery is how many rows are written between flushes, a failed flush means the client is gone
+const exportFlushEvery = 100
+
+// userExportListing filters the export the same way as the listing, plus ?format=
+var userExportListing = listing.Schema{
+	Fields: UserListing.Fields,
+	Search: UserListing.Search,
+	Key:    UserListing.Key,
+	Params: []string{"format"},
+}
+
+// ExportUsers : Stream every user matching the listing filters as NDJSON or CSV, ?format=ndjson|csv
+// or the Accept header picks the format, rows go from the database cursor straight to the client
+func ExportUsers(c *fiber.Ctx) error {
+	format, err := exportFormat(c)
+	if err != nil {
+		return err
+	}
+
+	// same filters, sort and search as GET /users, without pages
+	lq, err := listing.Parse(c, userExportListing)
+	if err != nil {
+		return err
+	}
+	args := make(listing.Args, 0, 4)
+	conditions := []string{"deleted_at IS NULL"}
+	if where := lq.Where(&args); where != "" {
+		conditions = append(conditions, where)
+	}
+	sql := "SELECT " + userColumns + " FROM users WHERE " + strings.Join(conditions, " AND ") + " ORDER BY " + lq.OrderBy()
+
+	// the body is written after the handler returns, so the query can't live on the request context,
+	// it gets its own that is cancelled as soon as the client stops reading, or once ExportTimeout is up
+	timeout := providers.GetConfiguration().Users.ExportTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	// here we only open the cursor, errors in the query itself still get a proper error response
+	db := database.Instance()
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("Error exporting users from database: %w", err)
+	}
+
+	c.Vary(fiber.HeaderAccept)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="users.`+format+`"`)
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, mimeCSV+"; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, mimeNDJSON)
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer rows.Close()
+
+		var csvWriter *csv.Writer
+		if format == "csv" {
+			csvWriter = csv.NewWriter(w)
+			_ = csvWriter.Write([]string{codec.Name("UserId"), codec.Name("Name"), codec.Name("Email"), codec.Name("Version"), codec.Name("CreatedAt"), codec.Name("UpdatedAt")})
+		}
+
+		written := 0
+		for rows.Next() {
+			ud := userData{}
+			if err := rows.Scan(ud.fields()...); err != nil {
+				fmt.Printf("Error scanning exported user: %s\n", err)
+				return
+			}
+
+			if csvWriter != nil {
+				if err := csvWriter.Write([]string{strconv.Itoa(ud.UserId), ud.Name, userBody(ud).Email, strconv.Itoa(ud.Version),
+					ud.CreatedAt.Format(time.RFC3339Nano), ud.UpdatedAt.Format(time.RFC3339Nano)}); err != nil {
+					return
+				}
+			} else {
+				line, err := codec.Marshal(ud)
+				if err != nil {
+					fmt.Printf("Error converting exported user to json: %s\n", err)
+					return
+				}
+				if _, err := w.Write(append(line, '\n')); err != nil {
+					// the client hung up, the deferred cancel stops the query
+					return
+				}
+			}
+
+			written++
+			if written%exportFlushEvery == 0 && !flushExport(w, csvWriter) {
+				// the client hung up, the deferred cancel stops the query
+				return
+			}
+		}
+		if rows.Err() != nil {
+			fmt.Printf("Error reading exported users from database: %s\n", rows.Err())
+			return
+		}
+		flushExport(w, csvWriter)
+	})
+	return nil
+}
+
+// exportFormat picks ndjson or csv from ?format= or else from Accept, NDJSON being the default
+func exportFormat(c *fiber.Ctx) (string, error) {
+	switch format := strings.ToLower(c.Query("format")); format {
+	case "ndjson", "csv":
+		return format, nil
+	case "":
+	default:
+		return "", apperrors.BadRequest("invalid_format", fmt.Sprintf("Unknown export format %q, use ndjson or csv.", format))
+	}
+
+	switch response.Accepts(c, mimeNDJSON, mimeCSV) {
+	case mimeNDJSON:
+		return "ndjson", nil
+	case mimeCSV:
+		return "csv", nil
+	}
+	return "", apperrors.New(fiber.StatusNotAcceptable, "not_acceptable", "Users can be exported as "+mimeNDJSON+" or "+mimeCSV+".")
+}
+
+// flushExport pushes what is buffered to the client, false when the client is gone
+func flushExport(w *bufio.Writer, csvWriter *csv.Writer) bool {
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if csvWriter.Error() != nil {
+			return false
+		}
+	}
+	return w.Flush() == nil
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/listing"
+	"github.com/mikeychowy/fiber-crayplate/app/pagination"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+)
+
+// the users in GraphQL, the resolvers run the same queries as the REST controllers
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.ID),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return strconv.Itoa(p.Source.(userData).UserId), nil
+			},
+		},
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userData).Name, nil
+			},
+		},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Null for the users created before it was required",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if email := p.Source.(userData).Email; email != nil {
+					return *email, nil
+				}
+				return nil, nil
+			},
+		},
+		"createdAt": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.DateTime),
+			Description: "Set by the database when the user is created",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userData).CreatedAt, nil
+			},
+		},
+		"updatedAt": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.DateTime),
+			Description: "Set by the database on every change",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userData).UpdatedAt, nil
+			},
+		},
+		"version": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Int),
+			Description: "Goes up with every change, send it back with updateUser to not overwrite someone else's change",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userData).Version, nil
+			},
+		},
+	},
+})
+
+// userPage is what the users query resolves to
+type userPage struct {
+	users     []userData
+	hasMore   bool
+	endCursor string
+}
+
+var userPageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserPage",
+	Fields: graphql.Fields{
+		"nodes": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userPage).users, nil
+			},
+		},
+		"hasNextPage": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Boolean),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(userPage).hasMore, nil
+			},
+		},
+		"endCursor": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Pass it as after to get the next page",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if page := p.Source.(userPage); page.endCursor != "" {
+					return page.endCursor, nil
+				}
+				return nil, nil
+			},
+		},
+	},
+})
+
+// userFilterType is one name[contains]=x of the REST listing, only what UserListing whitelists gets through
+var userFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"field": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(listingEnum("UserField", fieldNames(UserListing)))},
+		"op":    &graphql.InputObjectFieldConfig{Type: listingEnum("UserFilterOperator", operatorNames(UserListing)), DefaultValue: "eq"},
+		"value": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String), Description: "Comma separated for the in operator"},
+	},
+})
+
+var userInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"email": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+// UserQueries are the user fields of the GraphQL Query type
+func UserQueries() graphql.Fields {
+	return graphql.Fields{
+		"user": &graphql.Field{
+			Type: userType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := userID(p.Args["id"].(string))
+				if err != nil {
+					return nil, err
+				}
+				ud, err := findUser(p.Context, id, userFields)
+				if err != nil {
+					return nil, err
+				}
+				return ud, nil
+			},
+		},
+		"users": &graphql.Field{
+			Type:        graphql.NewNonNull(userPageType),
+			Description: "A page of users, filtered, sorted and searched like GET /users",
+			Args: graphql.FieldConfigArgument{
+				"first":  &graphql.ArgumentConfig{Type: graphql.Int, Description: "Page size"},
+				"after":  &graphql.ArgumentConfig{Type: graphql.String, Description: "endCursor of the previous page"},
+				"filter": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(userFilterType))},
+				"sort":   &graphql.ArgumentConfig{Type: graphql.String, Description: "Comma separated fields, prefix with - for descending"},
+				"search": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: resolveUsers,
+		},
+	}
+}
+
+// UserMutations are the user fields of the GraphQL Mutation type
+func UserMutations() graphql.Fields {
+	return graphql.Fields{
+		"createUser": &graphql.Field{
+			Type: graphql.NewNonNull(userType),
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInputType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				input, err := userInput(p.Args)
+				if err != nil {
+					return nil, err
+				}
+				ud, err := insertUser(p.Context, input, nil)
+				if err != nil {
+					return nil, err
+				}
+				return ud, nil
+			},
+		},
+		"updateUser": &graphql.Field{
+			Type: graphql.NewNonNull(userType),
+			Args: graphql.FieldConfigArgument{
+				"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInputType)},
+				"version": &graphql.ArgumentConfig{Type: graphql.Int, Description: "The version last seen, the update fails when the user has moved on"},
+			},
+			Resolve: resolveUpdateUser,
+		},
+		"deleteUser": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Boolean),
+			Description: "Moves a user to the trash, or removes it for good with force",
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				"force": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := userID(p.Args["id"].(string))
+				if err != nil {
+					return nil, err
+				}
+				if err := deleteUser(p.Context, id, p.Args["force"].(bool)); err != nil {
+					return nil, err
+				}
+				return true, nil
+			},
+		},
+	}
+}
+
+func resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	// same page sizes as the REST listing
+	config := providers.GetConfiguration().Users
+	page := pagination.Params{Limit: config.DefaultPageSize}
+	if first, ok := p.Args["first"].(int); ok {
+		if first < 1 {
+			return nil, apperrors.BadRequest("invalid_limit", "first must be a positive integer")
+		}
+		page.Limit = first
+	}
+	if config.MaxPageSize > 0 && page.Limit > config.MaxPageSize {
+		page.Limit = config.MaxPageSize
+	}
+	if after, ok := p.Args["after"].(string); ok && after != "" {
+		values, err := pagination.DecodeCursor(after)
+		if err != nil {
+			return nil, apperrors.BadRequest("invalid_cursor", "after is invalid, use the endCursor of a previous page")
+		}
+		page.After = values
+	}
+
+	// the arguments are turned into the query string of GET /users so the same rules apply
+	values := url.Values{}
+	if filters, ok := p.Args["filter"].([]interface{}); ok {
+		for _, raw := range filters {
+			// the schema asks for all three, but a null sent through a variable can still get this far
+			filter, _ := raw.(map[string]interface{})
+			key, okF := filter["field"].(string)
+			op, okO := filter["op"].(string)
+			value, okV := filter["value"].(string)
+			if !okF || !okO || !okV {
+				return nil, apperrors.BadRequest("invalid_filter", "every filter needs a field, an op and a value")
+			}
+			if op != "eq" {
+				key += "[" + op + "]"
+			}
+			values.Add(key, value)
+		}
+	}
+	if s, ok := p.Args["sort"].(string); ok {
+		values.Set("sort", s)
+	}
+	if search, ok := p.Args["search"].(string); ok {
+		values.Set("q", search)
+	}
+	lq, err := listing.ParseValues(UserListing, values)
+	if err != nil {
+		return nil, err
+	}
+
+	users, hasMore, nextCursor, err := listUsers(p.Context, lq, page, userFields)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMore {
+		// the REST listing hands a cursor out on every page, here endCursor null means there is nothing after
+		nextCursor = ""
+	}
+	return userPage{users: users, hasMore: hasMore, endCursor: nextCursor}, nil
+}
+
+func resolveUpdateUser(p graphql.ResolveParams) (interface{}, error) {
+	id, err := userID(p.Args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+	input, err := userInput(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	// version plays the part of If-Match, strict mode wants it on every update
+	version, hasVersion := p.Args["version"].(int)
+	if !hasVersion && providers.GetConfiguration().Users.RequireIfMatch {
+		return nil, validation.Failed(validation.Errors{{Field: "version", Code: "required", Message: "is required"}})
+	}
+
+	ud, err := updateUser(p.Context, id, input, []int{version}, !hasVersion)
+	if err == pgx.ErrNoRows {
+		current, errV := userVersion(p.Context, id)
+		if errV != nil {
+			return nil, errV
+		}
+		return nil, apperrors.New(fiber.StatusPreconditionFailed, "precondition_failed", fmt.Sprintf("User %d is at version %d.", id, current))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ud, nil
+}
+
+// userInput checks the input argument against the same rules as a POST /users body
+func userInput(args map[string]interface{}) (requestBodyStruct, error) {
+	input := requestBodyStruct{}
+	if raw, ok := args["input"].(map[string]interface{}); ok {
+		input.Name, _ = raw["name"].(string)
+		input.Email, _ = raw["email"].(string)
+	}
+	return input, validation.Check(&input)
+}
+
+// listingEnum is an enum of the names a listing whitelists
+func listingEnum(name string, values []string) *graphql.Enum {
+	enumValues := make(graphql.EnumValueConfigMap, len(values))
+	for _, value := range values {
+		enumValues[value] = &graphql.EnumValueConfig{Value: value}
+	}
+	return graphql.NewEnum(graphql.EnumConfig{Name: name, Values: enumValues})
+}
+
+func fieldNames(schema listing.Schema) []string {
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func operatorNames(schema listing.Schema) []string {
+	seen := map[string]bool{}
+	names := make([]string, 0, 8)
+	for _, field := range schema.Fields {
+		for _, op := range field.Operators {
+			if !seen[op] {
+				seen[op] = true
+				names = append(names, op)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// importReport tells what happened to every row of an upload, rows are counted from 1,
+// CSV rows without the header and NDJSON rows by line
+type importReport struct {
+	DryRun   bool
+	Total    int
+	Accepted int
+	Rejected int
+	// Rejections is an empty list, not null, when every row got in
+	Rejections []importRejection
+}
+
+type importRejection struct {
+	Row    int
+	Errors validation.Errors
+}
+
+// importRow is a valid row waiting for the COPY
+type importRow struct {
+	row  int
+	item requestBodyStruct
+}
+
+func (r *importReport) reject(row int, errs validation.Errors) {
+	r.Rejected++
+	r.Rejections = append(r.Rejections, importRejection{Row: row, Errors: errs})
+}
+
+// UserImportReport is the shape of the import report, exported so the routes can document it
+type UserImportReport = importReport
+
+// ImportUsers : Load users from an uploaded CSV (with a header row) or NDJSON file in the "file" field,
+// every row is validated and the valid ones are written with COPY, ?dry_run=true only validates
+func ImportUsers(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return apperrors.BadRequest("missing_file", "Upload the users as a CSV or NDJSON file in the \"file\" field of a multipart/form-data body.")
+	}
+	format, err := importFormat(c, fileHeader)
+	if err != nil {
+		return err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("error opening uploaded users file: %w", err)
+	}
+	defer file.Close()
+
+	report := importReport{DryRun: c.Query("dry_run") == "true" || c.FormValue("dry_run") == "true", Rejections: make([]importRejection, 0)}
+	valid := make([]importRow, 0, 64)
+	maxRows := providers.GetConfiguration().Users.MaxImportRows
+	// emails are unique whatever their case, a second row with the same one would fail the whole COPY
+	emailRows := make(map[string]int)
+
+	// every row goes through the same rules as POST /users
+	accept := func(row int, item requestBodyStruct, errs validation.Errors) error {
+		if maxRows > 0 && row > maxRows {
+			return validation.Failed(validation.Errors{{Field: "file", Code: "max", Message: fmt.Sprintf("must have at most %d rows", maxRows)}})
+		}
+		report.Total++
+		if len(errs) == 0 {
+			errs = validation.Validate(item)
+		}
+		if len(errs) == 0 {
+			if first, ok := emailRows[strings.ToLower(item.Email)]; ok {
+				errs = validation.Errors{{Field: "email", Code: "duplicate", Message: fmt.Sprintf("is already used by row %d", first)}}
+			} else {
+				emailRows[strings.ToLower(item.Email)] = row
+			}
+		}
+		if len(errs) > 0 {
+			report.reject(row, errs)
+			return nil
+		}
+		report.Accepted++
+		valid = append(valid, importRow{row: row, item: item})
+		return nil
+	}
+
+	if format == "csv" {
+		err = readImportCSV(file, accept)
+	} else {
+		err = readImportNDJSON(file, accept)
+	}
+	if err != nil {
+		return err
+	}
+
+	// rows whose email some user already has are rejected up front, dry runs included
+	valid, err = rejectTakenEmails(c.Context(), valid, &report)
+	if err != nil {
+		return err
+	}
+
+	if report.DryRun {
+		return response.Send(c, fiber.StatusOK, fmt.Sprintf("Dry run, %d of %d rows would be imported", report.Accepted, report.Total), report)
+	}
+	if report.Accepted == 0 {
+		return response.Send(c, fiber.StatusUnprocessableEntity, "No row could be imported", report, response.WithCode("import_failed"))
+	}
+
+	// here we load every valid row in a single COPY, far quicker than one INSERT per row
+	rows := make([][]interface{}, 0, len(valid))
+	emails := make([]string, 0, len(valid))
+	for _, r := range valid {
+		rows = append(rows, []interface{}{r.item.Name, r.item.Email})
+		emails = append(emails, strings.ToLower(r.item.Email))
+	}
+	var copied int64
+	err = inUserTx(c.Context(), func(tx pgx.Tx) error {
+		var errC error
+		copied, errC = tx.CopyFrom(c.Context(), pgx.Identifier{"users"}, []string{"name", "email"}, pgx.CopyFromRows(rows))
+		if errC != nil {
+			// someone took one of the emails since they were checked
+			var pgErr *pgconn.PgError
+			if errors.As(errC, &pgErr) && pgErr.Code == "23505" {
+				return apperrors.Wrap(errC, fiber.StatusConflict, "email_taken", "An email of the file was taken while it was being imported, nothing was imported.")
+			}
+			return fmt.Errorf("error copying imported users into database: %w", errC)
+		}
+		return enqueueImported(c.Context(), tx, emails)
+	})
+	if err != nil {
+		return err
+	}
+
+	return response.Send(c, fiber.StatusCreated, fmt.Sprintf("%d of %d rows were imported", copied, report.Total), report)
+}
+
+// importFormat picks csv or ndjson from ?format=, else from the file name, else from the part's content type
+func importFormat(c *fiber.Ctx, fileHeader *multipart.FileHeader) (string, error) {
+	switch format := strings.ToLower(c.Query("format")); format {
+	case "csv", "ndjson":
+		return format, nil
+	case "":
+	default:
+		return "", apperrors.BadRequest("invalid_format", fmt.Sprintf("Unknown import format %q, use csv or ndjson.", format))
+	}
+
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".csv":
+		return "csv", nil
+	case ".ndjson", ".jsonl":
+		return "ndjson", nil
+	}
+	switch strings.ToLower(strings.Split(fileHeader.Header.Get(fiber.HeaderContentType), ";")[0]) {
+	case mimeCSV:
+		return "csv", nil
+	case mimeNDJSON, "application/jsonl":
+		return "ndjson", nil
+	}
+	return "", apperrors.New(fiber.StatusUnsupportedMediaType, "unsupported_import_format", "Upload a .csv or .ndjson file, or pick one with ?format=csv|ndjson.")
+}
+
+// rejectTakenEmails moves the rows whose email is already in the users table to the rejections,
+// trashed users count as well since they can come back
+func rejectTakenEmails(ctx context.Context, valid []importRow, report *importReport) ([]importRow, error) {
+	if len(valid) == 0 {
+		return valid, nil
+	}
+	emails := make([]string, 0, len(valid))
+	for _, r := range valid {
+		emails = append(emails, strings.ToLower(r.item.Email))
+	}
+
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	rows, err := database.Instance().Query(ctx, "SELECT lower(email) FROM users WHERE lower(email) = ANY($1)", emails)
+	if err != nil {
+		return nil, fmt.Errorf("error checking the emails of imported users: %w", err)
+	}
+	defer rows.Close()
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var email string
+		if errR := rows.Scan(&email); errR != nil {
+			return nil, fmt.Errorf("error scanning the emails of imported users: %w", errR)
+		}
+		taken[email] = true
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error reading the emails of imported users: %w", rows.Err())
+	}
+	if len(taken) == 0 {
+		return valid, nil
+	}
+
+	kept := valid[:0]
+	for _, r := range valid {
+		if taken[strings.ToLower(r.item.Email)] {
+			report.Accepted--
+			report.reject(r.row, validation.Errors{{Field: "email", Code: "email_taken", Message: "is already used by another user"}})
+			continue
+		}
+		kept = append(kept, r)
+	}
+	// the report lists rows in file order
+	sort.SliceStable(report.Rejections, func(i, j int) bool { return report.Rejections[i].Row < report.Rejections[j].Row })
+	return kept, nil
+}
+
+// enqueueImported sends a user.created webhook for every imported user, COPY doesn't return the rows
+// it wrote but the emails are unique so they find them
+func enqueueImported(ctx context.Context, tx pgx.Tx, emails []string) error {
+	if !webhooks.Enabled() {
+		return nil
+	}
+	rows, err := tx.Query(ctx, "SELECT "+userColumns+" FROM users WHERE lower(email) = ANY($1) AND deleted_at IS NULL ORDER BY user_id", emails)
+	if err != nil {
+		return fmt.Errorf("error reading back imported users: %w", err)
+	}
+	imported := make([]userData, 0, len(emails))
+	for rows.Next() {
+		ud := userData{}
+		if errR := rows.Scan(ud.fields()...); errR != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning imported users: %w", errR)
+		}
+		imported = append(imported, ud)
+	}
+	// the connection is busy until the rows are closed
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("error reading imported users: %w", rows.Err())
+	}
+
+	for _, ud := range imported {
+		if err := webhooks.Enqueue(ctx, tx, webhooks.UserCreated, ud); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readImportCSV reads a CSV with a header row naming the columns like the JSON fields
+func readImportCSV(file io.Reader, accept func(int, requestBodyStruct, validation.Errors) error) error {
+	reader := csv.NewReader(file)
+	// rows with missing or extra cells are rejected one by one instead of failing the whole file
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return validation.Failed(validation.Errors{{Field: "file", Code: "required", Message: "is empty"}})
+	}
+	if err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	nameColumn, emailColumn := -1, -1
+	for i, column := range header {
+		// the first cell may carry the byte order mark spreadsheets like to add
+		switch strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")) {
+		case "name":
+			nameColumn = i
+		case "email":
+			emailColumn = i
+		}
+	}
+	if nameColumn < 0 || emailColumn < 0 {
+		return validation.Failed(validation.Errors{{Field: "file", Code: "missing_column", Message: "needs a header row with name and email columns"}})
+	}
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return fmt.Errorf("error reading uploaded users file: %w", err)
+			}
+			if errA := accept(row, requestBodyStruct{}, validation.Errors{{Field: "row", Code: "invalid_csv", Message: err.Error()}}); errA != nil {
+				return errA
+			}
+			continue
+		}
+		if len(record) != len(header) {
+			errs := validation.Errors{{Field: "row", Code: "invalid_csv", Message: fmt.Sprintf("has %d cells, the header has %d", len(record), len(header))}}
+			if errA := accept(row, requestBodyStruct{}, errs); errA != nil {
+				return errA
+			}
+			continue
+		}
+		if errA := accept(row, requestBodyStruct{Name: record[nameColumn], Email: record[emailColumn]}, nil); errA != nil {
+			return errA
+		}
+	}
+}
+
+// readImportNDJSON reads one JSON object per line, blank lines are skipped
+func readImportNDJSON(file io.Reader, accept func(int, requestBodyStruct, validation.Errors) error) error {
+	scanner := bufio.NewScanner(file)
+	// a single row may be longer than the default 64KB token
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	// rows are line numbers, so blank lines count even though they are skipped
+	row := 0
+	for scanner.Scan() {
+		row++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		item := requestBodyStruct{}
+		if err := codec.Unmarshal(line, &item); err != nil {
+			if errA := accept(row, item, validation.Errors{{Field: "row", Code: "invalid_json", Message: "is not a valid JSON object"}}); errA != nil {
+				return errA
+			}
+			continue
+		}
+		if errA := accept(row, item, nil); errA != nil {
+			return errA
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	return nil
+}
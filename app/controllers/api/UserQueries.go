@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/listing"
+	"github.com/mikeychowy/fiber-crayplate/app/pagination"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// the queries behind the users, shared by the REST controllers and the GraphQL resolvers
+
+// listUsers reads a page of the users listing and the cursor of the next page, empty on the last one,
+// only the fields named are read, they have to include the sort fields for the cursor
+func listUsers(ctx context.Context, lq listing.Query, page pagination.Params, fields []string) (users []userData, hasMore bool, nextCursor string, err error) {
+	// every value goes in as an argument, only whitelisted column names end up in the SQL itself
+	args := make(listing.Args, 0, 4)
+	conditions := []string{"deleted_at IS NULL"}
+	if where := lq.Where(&args); where != "" {
+		conditions = append(conditions, where)
+	}
+	// the cursor holds the sort values of the last row of the previous page
+	if len(page.After) > 0 {
+		seek, errS := lq.Seek(page.After, &args)
+		if errS != nil {
+			return nil, false, "", errS
+		}
+		conditions = append(conditions, seek)
+	}
+	sql := "SELECT " + strings.Join(fields, ", ") + " FROM users WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY " + lq.OrderBy() + " LIMIT " + args.Add(page.Limit+1)
+
+	// this is the slice to hold "data:[]" part of the response
+	users = make([]userData, 0, page.Limit+1)
+
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	// here we seek past the cursor and fetch one extra row to know if there is a next page
+	db := database.Instance()
+	rows, err := db.Query(ctx, sql, args...)
+
+	// pool error handling
+	if err != nil {
+		return nil, false, "", fmt.Errorf("Error returning all users from database: %w", err)
+	}
+
+	// so we don't forget to close the rows downstairs
+	defer rows.Close()
+
+	// iterate through all rows returned from db
+	for rows.Next() {
+		// create the user data holder struct, to describe how i would like the json data insides to be like
+		ud := userData{}
+		if errR := rows.Scan(ud.fieldsOf(fields)...); errR != nil {
+			return nil, false, "", fmt.Errorf("Error Scanning result set of users: %w", errR)
+		}
+		users = append(users, ud)
+	}
+
+	// rows error handler
+	if rows.Err() != nil {
+		return nil, false, "", fmt.Errorf("Error reading all users rows from database: %w", rows.Err())
+	}
+
+	// the extra row only tells us there is more, it belongs to the next page
+	hasMore = len(users) > page.Limit
+	if hasMore {
+		users = users[:page.Limit]
+	}
+	if len(users) > 0 {
+		last := users[len(users)-1]
+		nextCursor = pagination.EncodeCursor(lq.CursorValues(map[string]interface{}{
+			"user_id":    last.UserId,
+			"name":       last.Name,
+			"created_at": last.CreatedAt.Format(time.RFC3339Nano),
+			"updated_at": last.UpdatedAt.Format(time.RFC3339Nano),
+		})...)
+	}
+	return users, hasMore, nextCursor, nil
+}
+
+// userID reads the id of a user out of a path parameter or an argument, the ids are numbers
+// so anything else can't be a user and is a 404 before it gets anywhere near the database
+func userID(raw string) (int, error) {
+	id, err := strconv.Atoi(raw)
+	if err != nil || id <= 0 {
+		return 0, apperrors.NotFound("user_not_found", fmt.Sprintf("User %s not found.", raw))
+	}
+	return id, nil
+}
+
+// findUser reads the fields named of a user that is not in the trash
+func findUser(ctx context.Context, id int, fields []string) (userData, error) {
+	ud := userData{}
+	err := database.Instance().QueryRow(ctx, "SELECT "+strings.Join(fields, ", ")+" FROM users WHERE user_id=$1 AND deleted_at IS NULL", id).Scan(ud.fieldsOf(fields)...)
+
+	// scanner error handling, no row simply means there is no such user
+	if err == pgx.ErrNoRows {
+		return ud, apperrors.NotFound("user_not_found", fmt.Sprintf("User %d not found.", id))
+	}
+	if err != nil {
+		return ud, fmt.Errorf("Error returning user with id %d from the database: %w", id, err)
+	}
+	return ud, nil
+}
+
+// insertUser creates a user and reads it back in the same statement,
+// looking it up by name could pick a trashed user with the same name.
+// passwordHash is only set by registrations, it is never read back
+func insertUser(ctx context.Context, input requestBodyStruct, passwordHash *string) (userData, error) {
+	ud := userData{}
+	err := inUserTx(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, "INSERT INTO users(name, email, password_hash) VALUES($1, $2, $3) RETURNING "+userColumns, input.Name, input.Email, passwordHash).Scan(ud.fields()...); err != nil {
+			return emailTaken(fmt.Errorf("error inserting new user into database: %w", err), input.Email)
+		}
+		return webhooks.Enqueue(ctx, tx, webhooks.UserCreated, ud)
+	})
+	return ud, err
+}
+
+// updateUser overwrites a user, only if the row is still at one of versions (or at any with anyVersion),
+// and bumps the version so every other copy floating around becomes stale.
+// A user that is missing or at another version is a pgx.ErrNoRows, userVersion tells them apart
+func updateUser(ctx context.Context, id int, input requestBodyStruct, versions []int, anyVersion bool) (userData, error) {
+	ud := userData{}
+	err := inUserTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, "UPDATE users SET name=$1, email=$2, version=version+1 WHERE user_id=$3 AND deleted_at IS NULL AND ($4 OR version=ANY($5)) RETURNING "+userColumns, input.Name, input.Email, id, anyVersion, versions).Scan(ud.fields()...)
+		if err == pgx.ErrNoRows {
+			return err
+		}
+		if err != nil {
+			return emailTaken(fmt.Errorf("error updating specified user into database: %w", err), input.Email)
+		}
+		return webhooks.Enqueue(ctx, tx, webhooks.UserUpdated, ud)
+	})
+	return ud, err
+}
+
+// userVersion reads the current version of a user, a 404 when there is no such user
+func userVersion(ctx context.Context, id int) (int, error) {
+	var version int
+	err := database.Instance().QueryRow(ctx, "SELECT version FROM users WHERE user_id=$1 AND deleted_at IS NULL", id).Scan(&version)
+	if err == pgx.ErrNoRows {
+		return 0, apperrors.NotFound("user_not_found", fmt.Sprintf("User %d not found.", id))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error checking the version of specified user: %w", err)
+	}
+	return version, nil
+}
+
+// deleteUser moves a user to the trash, or removes it for good when forced,
+// a forced delete skips the trash and also works on users that are already in it
+func deleteUser(ctx context.Context, id int, force bool) error {
+	query := "UPDATE users SET deleted_at=now(), version=version+1 WHERE user_id=$1 AND deleted_at IS NULL RETURNING user_id"
+	if force {
+		query = "DELETE FROM users WHERE user_id=$1 RETURNING user_id"
+	}
+	return inUserTx(ctx, func(tx pgx.Tx) error {
+		deleted := userDeletedEvent{Force: force}
+		err := tx.QueryRow(ctx, query, id).Scan(&deleted.UserId)
+
+		// nothing was touched, so there is no such user to delete
+		if err == pgx.ErrNoRows {
+			return apperrors.NotFound("user_not_found", fmt.Sprintf("User %d not found.", id))
+		}
+		if err != nil {
+			return fmt.Errorf("error deleting specified user into database: %w", err)
+		}
+		return webhooks.Enqueue(ctx, tx, webhooks.UserDeleted, deleted)
+	})
+}
+
+// userDeletedEvent is the data of a user.deleted webhook, Force is false when the user went to the trash
+type userDeletedEvent struct {
+	UserId int
+	Force  bool
+}
+
+// inUserTx runs a change to the users in a transaction, along with the webhook events it enqueues,
+// so an event is only ever sent for a change that was kept
+func inUserTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	tx, err := database.Instance().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting the users transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing the users transaction: %w", err)
+	}
+	return nil
+}
+
+// emailTaken turns a write that hit the unique index on the email into a 409 the client can act on,
+// any other error is returned as it is
+func emailTaken(err error, email string) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+		return apperrors.Wrap(err, fiber.StatusConflict, "email_taken", fmt.Sprintf("Email %s is already used by another user.", email))
+	}
+	return err
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/pagination"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/validation"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+type webhookData struct {
+	SubscriptionId int
+	Url            string
+	Events         []string
+	CreatedAt      time.Time
+	// Secret signs the deliveries, it is only shown in the response that creates the subscription
+	Secret string `json:",omitempty"`
+}
+
+type webhookRequest struct {
+	Url    string   `json:"url" xml:"url" form:"url" validate:"required,max=2048,url"`
+	Events []string `json:"events" xml:"events" form:"events" validate:"required"`
+}
+
+type webhookDelivery struct {
+	DeliveryId     int64
+	SubscriptionId int
+	EventId        string
+	Event          string
+	Status         string
+	Attempts       int
+	NextAttemptAt  *time.Time `json:",omitempty"`
+	LastStatusCode *int
+	LastError      *string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+	// Payload and History are only in the response of a single delivery
+	Payload json.RawMessage  `json:",omitempty"`
+	History []webhookAttempt `json:",omitempty"`
+}
+
+type webhookAttempt struct {
+	StatusCode  *int
+	Error       *string
+	DurationMs  int
+	AttemptedAt time.Time
+}
+
+// Shapes of the webhooks API, exported so the routes can document them
+type (
+	Webhook         = webhookData
+	WebhookInput    = webhookRequest
+	WebhookDelivery = webhookDelivery
+)
+
+// deliveryColumns are the columns behind webhookDelivery, without the payload and the history
+const deliveryColumns = "delivery_id, subscription_id, event_id, event, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at"
+
+func (wd *webhookDelivery) fields() []interface{} {
+	return []interface{}{&wd.DeliveryId, &wd.SubscriptionId, &wd.EventId, &wd.Event, &wd.Status, &wd.Attempts, &wd.NextAttemptAt, &wd.LastStatusCode, &wd.LastError, &wd.CreatedAt, &wd.DeliveredAt}
+}
+
+// GetAllWebhooks : Respond every webhook subscription as JSON, without their secrets
+func GetAllWebhooks(c *fiber.Ctx) error {
+	// this is the slice to hold "data:[]" part of the response
+	dataSlice := make([]webhookData, 0, 16)
+
+	// not exactly the db instance, it's the pool instance
+	// but for all intents and purposes works exactly like db connection
+	db := database.Instance()
+	rows, err := db.Query(c.Context(), "SELECT subscription_id, url, events, created_at FROM webhook_subscriptions ORDER BY subscription_id")
+
+	// pool error handling
+	if err != nil {
+		return fmt.Errorf("Error returning webhook subscriptions from database: %w", err)
+	}
+
+	// so we don't forget to close the rows downstairs
+	defer rows.Close()
+
+	for rows.Next() {
+		wd := webhookData{}
+		if errR := rows.Scan(&wd.SubscriptionId, &wd.Url, &wd.Events, &wd.CreatedAt); errR != nil {
+			return fmt.Errorf("Error Scanning result set of webhook subscriptions: %w", errR)
+		}
+		dataSlice = append(dataSlice, wd)
+	}
+
+	// rows error handler
+	if rows.Err() != nil {
+		return fmt.Errorf("Error reading webhook subscriptions rows from database: %w", rows.Err())
+	}
+	return response.Send(c, fiber.StatusOK, "Here are all the webhook subscriptions", dataSlice)
+}
+
+// GetWebhook : Respond a single webhook subscription by id as JSON
+func GetWebhook(c *fiber.Ctx) error {
+	id, err := webhookID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	wd, err := findWebhook(c, id)
+	if err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusOK, "Here is the specified webhook subscription", wd)
+}
+
+// AddWebhook : Subscribe a URL to user events, {"url":"https://...","events":["user.created"]},
+// the response is the only one that carries the secret the deliveries are signed with
+func AddWebhook(c *fiber.Ctx) error {
+	rbod := new(webhookRequest)
+	if err := validation.Bind(c, rbod); err != nil {
+		return err
+	}
+	events, err := webhookEvents(rbod.Events)
+	if err != nil {
+		return err
+	}
+	if err := webhooks.CheckURL(c.Context(), rbod.Url); err != nil {
+		return validation.Failed(validation.Errors{{Field: "url", Code: "public_url", Message: "must be an http or https URL that resolves to public addresses"}})
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return err
+	}
+	wd := webhookData{Secret: secret}
+	err = database.Instance().QueryRow(c.Context(), "INSERT INTO webhook_subscriptions(url, secret, events) VALUES($1, $2, $3) RETURNING subscription_id, url, events, created_at",
+		rbod.Url, secret, events).Scan(&wd.SubscriptionId, &wd.Url, &wd.Events, &wd.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting new webhook subscription into database: %w", err)
+	}
+	return response.Send(c, fiber.StatusCreated, "Here is the new webhook subscription, keep the secret, it is not shown again", wd)
+}
+
+// DeleteWebhook : Remove a webhook subscription along with its deliveries
+func DeleteWebhook(c *fiber.Ctx) error {
+	queryID, err := webhookID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	tag, err := database.Instance().Exec(c.Context(), "DELETE FROM webhook_subscriptions WHERE subscription_id=$1", queryID)
+	if err != nil {
+		return fmt.Errorf("error deleting specified webhook subscription from database: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return apperrors.NotFound("webhook_not_found", fmt.Sprintf("Webhook subscription %d not found.", queryID))
+	}
+	return response.Send(c, fiber.StatusAccepted, fmt.Sprintf("Webhook subscription %d successfuly deleted.", queryID), nil)
+}
+
+// GetWebhookDeliveries : Respond the deliveries of a subscription, newest first, ?status=pending|delivered|dead
+// narrows them down and ?limit= and ?cursor= page through them like the users listing
+func GetWebhookDeliveries(c *fiber.Ctx) error {
+	id, err := webhookID(c.Params("id"))
+	if err != nil {
+		return err
+	}
+	wd, err := findWebhook(c, id)
+	if err != nil {
+		return err
+	}
+	config := providers.GetConfiguration().Users
+	page, err := pagination.FromRequest(c, config.DefaultPageSize, config.MaxPageSize)
+	if err != nil {
+		return err
+	}
+
+	sql := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE subscription_id=$1"
+	args := []interface{}{wd.SubscriptionId}
+	if status := c.Query("status"); status != "" {
+		if status != webhooks.Pending && status != webhooks.Delivered && status != webhooks.Dead {
+			return apperrors.BadRequest("invalid_status", "status must be one of "+webhooks.Pending+", "+webhooks.Delivered+", "+webhooks.Dead)
+		}
+		args = append(args, status)
+		sql += " AND status=$" + strconv.Itoa(len(args))
+	}
+	// the cursor holds the id of the last delivery of the previous page
+	if len(page.After) > 0 {
+		after, errA := strconv.ParseInt(fmt.Sprint(page.After[0]), 10, 64)
+		if errA != nil {
+			return apperrors.BadRequest("invalid_cursor", "cursor does not belong to this listing")
+		}
+		args = append(args, after)
+		sql += " AND delivery_id < $" + strconv.Itoa(len(args))
+	}
+	args = append(args, page.Limit+1)
+	sql += " ORDER BY delivery_id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := database.Instance().Query(c.Context(), sql, args...)
+	if err != nil {
+		return fmt.Errorf("Error returning webhook deliveries from database: %w", err)
+	}
+	defer rows.Close()
+
+	dataSlice := make([]webhookDelivery, 0, page.Limit+1)
+	for rows.Next() {
+		d := webhookDelivery{}
+		if errR := rows.Scan(d.fields()...); errR != nil {
+			return fmt.Errorf("Error Scanning result set of webhook deliveries: %w", errR)
+		}
+		dataSlice = append(dataSlice, d.public())
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("Error reading webhook deliveries rows from database: %w", rows.Err())
+	}
+
+	// the extra row only tells us there is more, it belongs to the next page
+	hasMore := len(dataSlice) > page.Limit
+	if hasMore {
+		dataSlice = dataSlice[:page.Limit]
+	}
+	nextCursor := ""
+	if len(dataSlice) > 0 {
+		nextCursor = pagination.EncodeCursor(dataSlice[len(dataSlice)-1].DeliveryId)
+	}
+	meta, links := pagination.Build(c, page, hasMore, nextCursor)
+	return response.Send(c, fiber.StatusOK, "Here are the deliveries of the webhook subscription", dataSlice, response.WithPage(meta, links))
+}
+
+// GetWebhookDelivery : Respond a single delivery with its payload and every attempt made to send it
+func GetWebhookDelivery(c *fiber.Ctx) error {
+	d, err := findDelivery(c)
+	if err != nil {
+		return err
+	}
+	return response.Send(c, fiber.StatusOK, "Here is the specified delivery", d)
+}
+
+// RedeliverWebhook : Send a delivery again as soon as possible, whatever became of it,
+// a dead delivery gets its full number of attempts back. An attempt still on its way when this lands
+// is logged but doesn't move the delivery on, the redelivery does
+func RedeliverWebhook(c *fiber.Ctx) error {
+	subscriptionID, deliveryID, err := deliveryParams(c)
+	if err != nil {
+		return err
+	}
+	d := webhookDelivery{}
+	err = database.Instance().QueryRow(c.Context(), "UPDATE webhook_deliveries SET status=$1, attempts=0, next_attempt_at=now() WHERE delivery_id=$2 AND subscription_id=$3 RETURNING "+deliveryColumns,
+		webhooks.Pending, deliveryID, subscriptionID).Scan(d.fields()...)
+	if err == pgx.ErrNoRows {
+		return deliveryNotFound(c)
+	}
+	if err != nil {
+		return fmt.Errorf("error scheduling the redelivery of specified delivery: %w", err)
+	}
+	return response.Send(c, fiber.StatusAccepted, "The delivery is on its way again", d.public())
+}
+
+// public hides when the next attempt is due on deliveries that won't have one
+func (wd webhookDelivery) public() webhookDelivery {
+	if wd.Status != webhooks.Pending {
+		wd.NextAttemptAt = nil
+	}
+	return wd
+}
+
+// webhookID reads the id of a subscription out of the path, the ids are numbers so anything else
+// can't be a subscription and is a 404 before it gets anywhere near the database
+func webhookID(raw string) (int, error) {
+	id, err := strconv.Atoi(raw)
+	if err != nil || id <= 0 {
+		return 0, apperrors.NotFound("webhook_not_found", fmt.Sprintf("Webhook subscription %s not found.", raw))
+	}
+	return id, nil
+}
+
+// deliveryParams reads the :id and :delivery parameters, numbers like webhookID
+func deliveryParams(c *fiber.Ctx) (int, int64, error) {
+	subscriptionID, errS := strconv.Atoi(c.Params("id"))
+	deliveryID, errD := strconv.ParseInt(c.Params("delivery"), 10, 64)
+	if errS != nil || errD != nil || subscriptionID <= 0 || deliveryID <= 0 {
+		return 0, 0, deliveryNotFound(c)
+	}
+	return subscriptionID, deliveryID, nil
+}
+
+func deliveryNotFound(c *fiber.Ctx) error {
+	return apperrors.NotFound("delivery_not_found", fmt.Sprintf("Delivery %s of webhook subscription %s not found.", c.Params("delivery"), c.Params("id")))
+}
+
+func findWebhook(c *fiber.Ctx, id int) (webhookData, error) {
+	wd := webhookData{}
+	err := database.Instance().QueryRow(c.Context(), "SELECT subscription_id, url, events, created_at FROM webhook_subscriptions WHERE subscription_id=$1", id).
+		Scan(&wd.SubscriptionId, &wd.Url, &wd.Events, &wd.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return wd, apperrors.NotFound("webhook_not_found", fmt.Sprintf("Webhook subscription %d not found.", id))
+	}
+	if err != nil {
+		return wd, fmt.Errorf("error returning webhook subscription with id %d from the database: %w", id, err)
+	}
+	return wd, nil
+}
+
+// findDelivery reads the delivery of the :id and :delivery parameters with its payload and history
+func findDelivery(c *fiber.Ctx) (webhookDelivery, error) {
+	d := webhookDelivery{}
+	subscriptionID, deliveryID, err := deliveryParams(c)
+	if err != nil {
+		return d, err
+	}
+	var payload string
+	db := database.Instance()
+	err = db.QueryRow(c.Context(), "SELECT "+deliveryColumns+", payload::text FROM webhook_deliveries WHERE delivery_id=$1 AND subscription_id=$2",
+		deliveryID, subscriptionID).Scan(append(d.fields(), &payload)...)
+	if err == pgx.ErrNoRows {
+		return d, deliveryNotFound(c)
+	}
+	if err != nil {
+		return d, fmt.Errorf("error returning specified delivery from the database: %w", err)
+	}
+	d = d.public()
+	d.Payload = json.RawMessage(payload)
+
+	rows, err := db.Query(c.Context(), "SELECT status_code, error, duration_ms, attempted_at FROM webhook_attempts WHERE delivery_id=$1 ORDER BY attempt_id", d.DeliveryId)
+	if err != nil {
+		return d, fmt.Errorf("error returning the attempts of specified delivery: %w", err)
+	}
+	defer rows.Close()
+	d.History = make([]webhookAttempt, 0, d.Attempts)
+	for rows.Next() {
+		a := webhookAttempt{}
+		if errR := rows.Scan(&a.StatusCode, &a.Error, &a.DurationMs, &a.AttemptedAt); errR != nil {
+			return d, fmt.Errorf("error scanning the attempts of specified delivery: %w", errR)
+		}
+		d.History = append(d.History, a)
+	}
+	if rows.Err() != nil {
+		return d, fmt.Errorf("error reading the attempts of specified delivery: %w", rows.Err())
+	}
+	return d, nil
+}
+
+// webhookEvents checks the events of a subscription and drops the repeated ones
+func webhookEvents(events []string) ([]string, error) {
+	seen := make(map[string]bool, len(events))
+	unique := make([]string, 0, len(events))
+	errs := make(validation.Errors, 0)
+	for i, event := range events {
+		if !webhooks.IsEvent(event) {
+			errs = append(errs, validation.FieldError{Field: "events[" + strconv.Itoa(i) + "]", Code: "oneof", Message: "must be one of " + strings.Join(webhooks.Events, ", ")})
+			continue
+		}
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, validation.Failed(errs)
+	}
+	return unique, nil
+}
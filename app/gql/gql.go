@@ -0,0 +1,188 @@
+package gql
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// Config of the GraphQL endpoint
+type Config struct {
+	// Deepest selection a query may have, introspection fields are held to MaxIntrospectionDepth instead
+	MaxDepth int
+	// Deepest an introspection field (__schema, __type) may go, tools ask for type references many levels down
+	MaxIntrospectionDepth int
+	// Most fields a query may resolve, fields under a list count as many times as the list's first argument
+	MaxComplexity int
+	// Show the internal cause of errors, follows the debug mode of the app
+	Debug bool
+	// Items a list returns without a first argument and most it returns with one,
+	// they follow the page sizes of users.yaml
+	DefaultListSize int `mapstructure:"-"`
+	MaxListSize     int `mapstructure:"-"`
+}
+
+// ConfigDefault is used for every field left empty
+var ConfigDefault = Config{
+	MaxDepth:              8,
+	MaxIntrospectionDepth: 15,
+	MaxComplexity:         1000,
+}
+
+// request is a GraphQL request, in a JSON body or in the query string of a GET
+type request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// Handler executes GraphQL requests against schema, queries can be sent with GET or POST
+// and mutations only with POST. Queries over the limits are refused before anything runs
+func Handler(schema graphql.Schema, config Config) fiber.Handler {
+	if config.MaxDepth == 0 {
+		config.MaxDepth = ConfigDefault.MaxDepth
+	}
+	if config.MaxIntrospectionDepth == 0 {
+		config.MaxIntrospectionDepth = ConfigDefault.MaxIntrospectionDepth
+	}
+	if config.MaxComplexity == 0 {
+		config.MaxComplexity = ConfigDefault.MaxComplexity
+	}
+
+	return func(c *fiber.Ctx) error {
+		req, err := readRequest(c)
+		if err != nil {
+			return err
+		}
+		if req.Query == "" {
+			return send(c, fiber.StatusBadRequest, requestError("query_required", "The request has no query."))
+		}
+
+		doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+		if err != nil {
+			return send(c, fiber.StatusBadRequest, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		}
+
+		op := operation(doc, req.OperationName)
+		if op == nil {
+			return send(c, fiber.StatusBadRequest, requestError("operation_not_found", "The request has no operation to run, or several and no operationName."))
+		}
+		// a GET must never change anything, caches and prefetchers replay them freely
+		if c.Method() == fiber.MethodGet && op.Operation != ast.OperationTypeQuery {
+			c.Set(fiber.HeaderAllow, fiber.MethodPost)
+			return send(c, fiber.StatusMethodNotAllowed, requestError("mutation_over_get", "Mutations have to be sent with POST."))
+		}
+
+		if result := checkLimits(schema, doc, op, req.Variables, config); result != nil {
+			return send(c, fiber.StatusBadRequest, result)
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        c.Context(),
+		})
+		publicErrors(result, config.Debug)
+		return send(c, fiber.StatusOK, result)
+	}
+}
+
+// readRequest reads the query, variables and operation name of a GET or a POST,
+// POST bodies are JSON or a bare application/graphql query
+func readRequest(c *fiber.Ctx) (request, error) {
+	req := request{}
+	if c.Method() == fiber.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := codec.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return req, apperrors.BadRequest("invalid_variables", "variables must be a JSON object.")
+			}
+		}
+		return req, nil
+	}
+
+	if string(c.Request().Header.ContentType()) == "application/graphql" {
+		req.Query = string(c.Body())
+		return req, nil
+	}
+	if err := codec.Unmarshal(c.Body(), &req); err != nil {
+		return req, apperrors.InvalidBody(err)
+	}
+	return req, nil
+}
+
+// operation picks the operation to run, by name when there are several
+func operation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return found
+}
+
+// publicErrors replaces the errors of the resolvers with what clients may see of them,
+// their code goes in the extensions and the internal cause is only shown in debug mode
+func publicErrors(result *graphql.Result, debug bool) {
+	for i, formatted := range result.Errors {
+		located, ok := formatted.OriginalError().(*gqlerrors.Error)
+		if !ok || located.OriginalError == nil {
+			// syntax and validation errors are about the query, they are safe to show as they are
+			continue
+		}
+
+		appErr := apperrors.From(located.OriginalError)
+		if appErr.Status >= fiber.StatusInternalServerError {
+			// the response is a 200 anyway, don't let the failure go unnoticed
+			log.Printf("GraphQL resolver failed at %v: %v", formatted.Path, located.OriginalError)
+		}
+
+		extensions := map[string]interface{}{"code": appErr.Code, "status": appErr.Status}
+		if appErr.Details != nil {
+			extensions["details"] = appErr.Details
+		}
+		if debug && appErr.Err != nil {
+			extensions["debug"] = appErr.Err.Error()
+		}
+		result.Errors[i].Message = appErr.Message
+		result.Errors[i].Extensions = extensions
+	}
+}
+
+// requestError is a result that didn't get to run, for a request that is wrong as a whole
+func requestError(code, message string) *graphql.Result {
+	formatted := gqlerrors.NewFormattedError(message)
+	formatted.Extensions = map[string]interface{}{"code": code}
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{formatted}}
+}
+
+// send writes a result the way GraphQL clients expect it, without the envelope of the REST API
+func send(c *fiber.Ctx, status int, result *graphql.Result) error {
+	body, err := codec.Marshal(result)
+	if err != nil {
+		return err
+	}
+	c.Status(status)
+	c.Type("json")
+	return c.Send(body)
+}
@@ -0,0 +1,223 @@
+package gql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// listArguments size the lists, a field taking one of them returns that many items at most
+var listArguments = []string{"first", "limit"}
+
+// cost walks the selections of an operation to measure them before anything runs
+type cost struct {
+	schema    graphql.Schema
+	config    Config
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// fragments being walked, a fragment spreading itself is left to the validation
+	visiting map[string]bool
+}
+
+// checkLimits refuses operations deeper or more complex than the config allows
+func checkLimits(schema graphql.Schema, doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}, config Config) *graphql.Result {
+	walker := cost{
+		schema:    schema,
+		config:    config,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: withDefaults(op, variables),
+		visiting:  make(map[string]bool),
+	}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			walker.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	// the types tell which fields are lists, a nil *graphql.Object must not end up in the interface
+	var root graphql.Type
+	if query := schema.QueryType(); query != nil && op.Operation == ast.OperationTypeQuery {
+		root = query
+	}
+	if mutation := schema.MutationType(); mutation != nil && op.Operation == ast.OperationTypeMutation {
+		root = mutation
+	}
+	complexity, depth, introspection := walker.selections(op.SelectionSet, root, 0)
+	if depth > config.MaxDepth {
+		return requestError("query_too_deep", fmt.Sprintf("The query is %d levels deep, at most %d are allowed.", depth, config.MaxDepth))
+	}
+	if introspection > config.MaxIntrospectionDepth {
+		return requestError("query_too_deep", fmt.Sprintf("The introspection query is %d levels deep, at most %d are allowed.", introspection, config.MaxIntrospectionDepth))
+	}
+	if complexity > config.MaxComplexity {
+		return requestError("query_too_complex", fmt.Sprintf("The query has a complexity of %d, at most %d is allowed.", complexity, config.MaxComplexity))
+	}
+	return nil
+}
+
+// withDefaults adds the declared defaults of the variables the request left out,
+// a list sized by a variable is as long with its default as with the same value sent
+func withDefaults(op *ast.OperationDefinition, variables map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(variables)+len(op.VariableDefinitions))
+	for name, value := range variables {
+		merged[name] = value
+	}
+	for _, definition := range op.VariableDefinitions {
+		if definition.Variable == nil || definition.DefaultValue == nil {
+			continue
+		}
+		if _, sent := merged[definition.Variable.Name.Value]; sent {
+			continue
+		}
+		if value, ok := definition.DefaultValue.(*ast.IntValue); ok {
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				merged[definition.Variable.Name.Value] = n
+			}
+		}
+	}
+	return merged
+}
+
+// selections returns the complexity of a selection set, the deepest level it reaches
+// and the deepest level reached under an introspection field. parent is the type the selections are made on,
+// nil where the schema doesn't tell
+func (w cost) selections(set *ast.SelectionSet, parent graphql.Type, depth int) (complexity, deepest, introspection int) {
+	deepest = depth
+	if set == nil {
+		return 0, deepest, 0
+	}
+
+	for _, selection := range set.Selections {
+		var c, d, i int
+		switch s := selection.(type) {
+		case *ast.Field:
+			definition := fieldDefinition(parent, s.Name.Value)
+			var next graphql.Type
+			if definition != nil {
+				next, _ = graphql.GetNamed(definition.Type).(graphql.Type)
+			}
+			c, d, i = w.selections(s.SelectionSet, next, depth+1)
+			c = 1 + w.listSize(definition, s.Arguments)*c
+			// introspection costs like any other field, but how deep it goes has a limit of its own,
+			// the queries tools send on their own nest type references well past MaxDepth
+			if strings.HasPrefix(s.Name.Value, "__") {
+				if d > i {
+					i = d
+				}
+				d = depth
+			}
+		case *ast.InlineFragment:
+			c, d, i = w.selections(s.SelectionSet, w.typeCondition(s.TypeCondition, parent), depth)
+		case *ast.FragmentSpread:
+			fragment, ok := w.fragments[s.Name.Value]
+			if !ok || w.visiting[s.Name.Value] {
+				continue
+			}
+			w.visiting[s.Name.Value] = true
+			c, d, i = w.selections(fragment.SelectionSet, w.typeCondition(fragment.TypeCondition, parent), depth)
+			delete(w.visiting, s.Name.Value)
+		}
+		complexity += c
+		if d > deepest {
+			deepest = d
+		}
+		if i > introspection {
+			introspection = i
+		}
+	}
+	return complexity, deepest, introspection
+}
+
+// listSize is how many items a field asks for, capped like the resolvers cap it. A field that takes
+// a first argument and was given none returns the default page size, fields that aren't lists count 1
+func (w cost) listSize(definition *graphql.FieldDefinition, arguments []*ast.Argument) int {
+	for _, argument := range arguments {
+		if !isListArgument(argument.Name.Value) {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil && n > 0 {
+				return w.capped(n)
+			}
+		case *ast.Variable:
+			switch n := w.variables[value.Name.Value].(type) {
+			case float64:
+				if n > 0 {
+					return w.capped(int(n))
+				}
+			case int:
+				if n > 0 {
+					return w.capped(n)
+				}
+			}
+		}
+	}
+
+	// no size or a null one, the list is as long as the resolver makes it by default
+	if definition != nil {
+		for _, argument := range definition.Args {
+			if !isListArgument(argument.Name()) {
+				continue
+			}
+			if n, ok := argument.DefaultValue.(int); ok && n > 0 {
+				return w.capped(n)
+			}
+			return w.capped(w.config.DefaultListSize)
+		}
+	}
+	return 1
+}
+
+// capped keeps a list size between 1 and MaxListSize
+func (w cost) capped(n int) int {
+	if w.config.MaxListSize > 0 && n > w.config.MaxListSize {
+		n = w.config.MaxListSize
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// fieldDefinition looks a field up on the type it is selected on, nil when the schema doesn't have it
+func fieldDefinition(parent graphql.Type, name string) *graphql.FieldDefinition {
+	switch name {
+	case graphql.SchemaMetaFieldDef.Name:
+		return graphql.SchemaMetaFieldDef
+	case graphql.TypeMetaFieldDef.Name:
+		return graphql.TypeMetaFieldDef
+	case graphql.TypeNameMetaFieldDef.Name:
+		return graphql.TypeNameMetaFieldDef
+	}
+	switch t := parent.(type) {
+	case *graphql.Object:
+		return t.Fields()[name]
+	case *graphql.Interface:
+		return t.Fields()[name]
+	}
+	return nil
+}
+
+// typeCondition is the type a fragment applies to, the type it is spread on when it names none
+func (w cost) typeCondition(condition *ast.Named, parent graphql.Type) graphql.Type {
+	if condition == nil || condition.Name == nil {
+		return parent
+	}
+	if t := w.schema.Type(condition.Name.Value); t != nil {
+		return t
+	}
+	return parent
+}
+
+func isListArgument(name string) bool {
+	for _, argument := range listArguments {
+		if name == argument {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Config of the Cache-Control headers
+type Config struct {
+	// Default Cache-Control of the routes that are not in Routes, nothing is set when empty
+	Default string
+	// Cache-Control by route name, the names are the ones given to Control
+	Routes map[string]string
+}
+
+// ConfigDefault makes clients check with the server before reusing what they have,
+// which is cheap now that unchanged resources are a 304
+var ConfigDefault = Config{
+	Default: "private, no-cache",
+}
+
+var config = ConfigDefault
+
+// Configure sets the Cache-Control of the routes, call it once at startup before the server starts listening
+func Configure(c Config) {
+	routes := make(map[string]string, len(c.Routes))
+	for name, value := range c.Routes {
+		// viper lowercases the keys, the names given to Control may not be
+		routes[strings.ToLower(name)] = value
+	}
+	c.Routes = routes
+	config = c
+}
+
+// Control sets the Cache-Control configured for the route name on its successful responses,
+// errors are left alone so a failure never gets cached for as long as the resource would
+func Control(name string) fiber.Handler {
+	name = strings.ToLower(name)
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		value, ok := config.Routes[name]
+		if !ok {
+			value = config.Default
+		}
+		if status := c.Response().StatusCode(); value != "" && status < fiber.StatusBadRequest {
+			c.Set(fiber.HeaderCacheControl, value)
+		}
+		return nil
+	}
+}
+
+// NotModified sets the ETag and Last-Modified of a resource and tells whether the client already has it,
+// in which case the response is turned into a 304 and the handler must not write a body.
+// etag and lastModified can each be empty, If-Modified-Since only counts when there is no If-None-Match
+func NotModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		c.Set(fiber.HeaderETag, etag)
+	}
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		notModified = etag != "" && matches(ifNoneMatch, etag)
+	} else if ifModifiedSince := c.Get(fiber.HeaderIfModifiedSince); ifModifiedSince != "" && !lastModified.IsZero() {
+		// HTTP dates have no fractions of a second, the resource is unchanged if it is no newer than the date
+		since, err := http.ParseTime(ifModifiedSince)
+		notModified = err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+
+	if notModified {
+		// the 304 stands for the representation the client negotiated, like the 200 would
+		c.Vary(fiber.HeaderAccept)
+		c.Status(fiber.StatusNotModified)
+	}
+	return notModified
+}
+
+// WeakETag is a weak ETag made of parts, for representations put together from several rows,
+// the same parts in the same order give the same ETag
+func WeakETag(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		// keeps "ab","c" and "a","bc" apart
+		hash.Write([]byte{0})
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// matches compares the tags of If-None-Match with etag the weak way, W/"1" and "1" are the same
+func matches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+)
+
+// Config of the Idempotency-Key middleware
+type Config struct {
+	// Request header carrying the key
+	Header string
+	// How long a key and its stored response are kept
+	TTL time.Duration
+	// Methods the header is honoured on, safe methods never need it
+	Methods []string
+	// Longest key accepted
+	MaxKeyLength int
+	// How often expired keys are removed
+	PurgeInterval time.Duration
+}
+
+// ConfigDefault is used for every field left empty
+var ConfigDefault = Config{
+	Header:        "Idempotency-Key",
+	TTL:           24 * time.Hour,
+	Methods:       []string{fiber.MethodPost, fiber.MethodPatch},
+	MaxKeyLength:  255,
+	PurgeInterval: time.Hour,
+}
+
+// Record is what is kept for a key, a zero Status means the first request is still in flight
+type Record struct {
+	Fingerprint string
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+}
+
+// Store keeps the keys, in Postgres or in memory when there is no database
+type Store interface {
+	// Lock claims key for a new request, when key is already claimed and not expired
+	// the existing record is returned instead and nothing changes
+	Lock(c context.Context, key, fingerprint string, ttl time.Duration) (existing *Record, err error)
+	// Save stores the response of the request that claimed key
+	Save(c context.Context, key string, record Record) error
+	// Release gives key up so the request can be tried again
+	Release(c context.Context, key string) error
+	// Purge removes expired keys and returns how many there were
+	Purge(c context.Context) (int64, error)
+}
+
+// headers that are replayed along with the status and body
+var replayed = []string{fiber.HeaderContentType, fiber.HeaderETag, fiber.HeaderLocation}
+
+// New creates the middleware, the first response to a key is stored and replayed to every retry
+// that carries the same key and body, responses the server failed on (5xx) are not kept
+func New(config Config, store Store) fiber.Handler {
+	config = withDefaults(config)
+	methods := make(map[string]bool, len(config.Methods))
+	for _, method := range config.Methods {
+		methods[strings.ToUpper(method)] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(config.Header)
+		if key == "" || !methods[c.Method()] {
+			return c.Next()
+		}
+		if !validKey(key, config.MaxKeyLength) {
+			return apperrors.BadRequest("invalid_idempotency_key", "The "+config.Header+" header must be 1 to "+strconv.Itoa(config.MaxKeyLength)+" visible ASCII characters.")
+		}
+
+		// every user has keys of their own, two clients picking the same key never see each other's responses
+		key = scopeOf(c) + key
+		fingerprint := fingerprintOf(c)
+		existing, err := store.Lock(c.Context(), key, fingerprint, config.TTL)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if existing.Fingerprint != fingerprint {
+				return apperrors.New(fiber.StatusUnprocessableEntity, "idempotency_key_reused", "This "+config.Header+" was already used for a different request.")
+			}
+			if existing.Status == 0 {
+				return apperrors.Conflict("idempotency_key_in_use", "A request with this "+config.Header+" is still being processed.")
+			}
+			return replay(c, existing)
+		}
+
+		// if anything below panics the key must not stay locked until it expires
+		saved := false
+		defer func() {
+			if !saved {
+				_ = store.Release(c.Context(), key)
+			}
+		}()
+
+		// errors are turned into responses here already, the stored response has to be the final one
+		if err := c.Next(); err != nil {
+			if errH := c.App().Config().ErrorHandler(c, err); errH != nil {
+				return errH
+			}
+		}
+
+		// no-store responses carry credentials like access tokens, they are not written down anywhere
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError || strings.Contains(string(c.Response().Header.Peek(fiber.HeaderCacheControl)), "no-store") {
+			return nil
+		}
+		record := Record{Fingerprint: fingerprint, Status: status, Headers: make(map[string]string, len(replayed))}
+		for _, header := range replayed {
+			if value := c.Response().Header.Peek(header); len(value) > 0 {
+				record.Headers[header] = string(value)
+			}
+		}
+		record.Body = append([]byte(nil), c.Response().Body()...)
+		// the request went through by now, failing it would only make the client retry a change that was made.
+		// The key stays locked instead, retries get a 409 until it expires rather than running the change twice
+		saved = true
+		if err := store.Save(c.Context(), key, record); err != nil {
+			log.Printf("%s %q: the response could not be stored, the key stays locked until it expires: %v", config.Header, key, err)
+		}
+		return nil
+	}
+}
+
+// replay writes a stored response back
+func replay(c *fiber.Ctx, record *Record) error {
+	for header, value := range record.Headers {
+		c.Set(header, value)
+	}
+	c.Set("Idempotent-Replayed", "true")
+	c.Status(record.Status)
+	return c.Send(record.Body)
+}
+
+// scopeOf is the namespace of the keys of a request, the user of its access token,
+// requests made while auth is disabled share one
+func scopeOf(c *fiber.Ctx) string {
+	if claims := auth.ClaimsFrom(c.Context()); claims != nil {
+		return "user:" + claims.Subject + "/"
+	}
+	return "anonymous/"
+}
+
+// fingerprintOf identifies a request by its method, URL and body, the token isn't part of it
+// so a retry with a refreshed token still gets the stored response
+func fingerprintOf(c *fiber.Ctx) string {
+	sum := sha256.New()
+	sum.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	sum.Write(c.Body())
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func validKey(key string, max int) bool {
+	if len(key) > max {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func withDefaults(config Config) Config {
+	if config.Header == "" {
+		config.Header = ConfigDefault.Header
+	}
+	if config.TTL <= 0 {
+		config.TTL = ConfigDefault.TTL
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = ConfigDefault.Methods
+	}
+	if config.MaxKeyLength <= 0 {
+		config.MaxKeyLength = ConfigDefault.MaxKeyLength
+	}
+	return config
+}
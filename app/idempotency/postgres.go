@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresStore keeps the keys in the idempotency_keys table, shared by every instance of the API
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresStore creates a PostgresStore on top of the pool
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+// Lock implements Store, the insert only wins when the key is new or expired so two
+// concurrent requests with the same key can never both go through
+func (s *PostgresStore) Lock(c context.Context, key, fingerprint string, ttl time.Duration) (*Record, error) {
+	var claimed string
+	err := s.pool.QueryRow(c, `INSERT INTO idempotency_keys (key, fingerprint, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET fingerprint=EXCLUDED.fingerprint, status=NULL, headers=NULL, body=NULL,
+			created_at=now(), expires_at=EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= now()
+		RETURNING key`, key, fingerprint, time.Now().Add(ttl)).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("error locking idempotency key: %w", err)
+	}
+
+	// someone else holds the key, hand back what they stored so far
+	existing := &Record{}
+	var status *int
+	err = s.pool.QueryRow(c, "SELECT fingerprint, status, headers, body FROM idempotency_keys WHERE key=$1", key).Scan(&existing.Fingerprint, &status, &existing.Headers, &existing.Body)
+	if err == pgx.ErrNoRows {
+		// released right in between, the client can simply retry
+		return &Record{Fingerprint: fingerprint}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading idempotency key: %w", err)
+	}
+	if status != nil {
+		existing.Status = *status
+	}
+	return existing, nil
+}
+
+// Save implements Store
+func (s *PostgresStore) Save(c context.Context, key string, record Record) error {
+	_, err := s.pool.Exec(c, "UPDATE idempotency_keys SET status=$2, headers=$3, body=$4 WHERE key=$1", key, record.Status, record.Headers, record.Body)
+	if err != nil {
+		return fmt.Errorf("error saving idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Release implements Store
+func (s *PostgresStore) Release(c context.Context, key string) error {
+	_, err := s.pool.Exec(c, "DELETE FROM idempotency_keys WHERE key=$1 AND status IS NULL", key)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Purge implements Store
+func (s *PostgresStore) Purge(c context.Context) (int64, error) {
+	tag, err := s.pool.Exec(c, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
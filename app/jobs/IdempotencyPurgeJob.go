@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikeychowy/fiber-crayplate/app/idempotency"
+)
+
+// StartIdempotencyPurge removes expired idempotency keys every interval until the context is done
+func StartIdempotencyPurge(c context.Context, store idempotency.Store, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+			}
+
+			purged, err := store.Purge(c)
+			if err != nil {
+				fmt.Printf("Error purging idempotency keys: %s\n", err)
+			} else if purged > 0 {
+				fmt.Printf("Purged %d expired idempotency keys\n", purged)
+			}
+		}
+	}()
+}
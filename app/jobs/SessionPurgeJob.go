@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// PurgeEndedSessions removes the sessions whose last refresh token expired, along with their refresh tokens.
+// Revoked sessions are kept until then too, a replayed token of theirs is refused either way
+func PurgeEndedSessions(c context.Context) (int64, error) {
+	tag, err := database.Instance().Exec(c, "DELETE FROM sessions WHERE expires_at < now()")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// StartSessionPurge runs PurgeEndedSessions every interval until the context is done
+func StartSessionPurge(c context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := PurgeEndedSessions(c)
+			if err != nil {
+				fmt.Printf("Error purging ended sessions: %s\n", err)
+			} else if purged > 0 {
+				fmt.Printf("Purged %d ended sessions\n", purged)
+			}
+
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikeychowy/fiber-crayplate/app/configuration"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// PurgeTrashedUsers permanently removes users that have been in the trash longer than the retention
+func PurgeTrashedUsers(c context.Context, retention time.Duration) (int64, error) {
+	db := database.Instance()
+	tag, err := db.Exec(c, "DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// StartUserPurge runs PurgeTrashedUsers every PurgeInterval until the context is done
+func StartUserPurge(c context.Context, config configuration.UsersConfiguration) {
+	// a zero retention means trashed users are kept forever
+	if config.TrashRetention <= 0 || config.PurgeInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := PurgeTrashedUsers(c, config.TrashRetention)
+			if err != nil {
+				fmt.Printf("Error purging trashed users: %s\n", err)
+			} else if purged > 0 {
+				fmt.Printf("Purged %d trashed users\n", purged)
+			}
+
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
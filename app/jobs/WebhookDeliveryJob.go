@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/database"
+)
+
+// StartWebhookDelivery sends the webhook deliveries that are due every interval until the context is done
+func StartWebhookDelivery(c context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := webhooks.DeliverDue(c, database.Instance()); err != nil {
+				fmt.Printf("Error delivering webhooks: %s\n", err)
+			}
+		}
+	}()
+}
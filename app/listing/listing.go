@@ -0,0 +1,355 @@
+package listing
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+)
+
+// FieldType tells how the raw values of a field are parsed before they hit SQL
+type FieldType int
+
+const (
+	// Int fields hold whole numbers
+	Int FieldType = iota
+	// String fields hold text
+	String
+	// Time fields hold RFC 3339 timestamps
+	Time
+)
+
+// Field is a column clients are allowed to filter and sort on
+type Field struct {
+	Column    string
+	Type      FieldType
+	Operators []string
+	Sortable  bool
+	// CaseInsensitive String fields compare lower(column) on eq, ne and in, like an email the way logins look it up
+	CaseInsensitive bool
+}
+
+// Schema whitelists what a listing endpoint accepts
+type Schema struct {
+	// Fields by the name clients use
+	Fields map[string]Field
+	// Columns searched by ?q=
+	Search []string
+	// Sortable, unique, non null field every order ends with so keyset pagination is stable
+	Key string
+	// Other query parameters the endpoint understands and that are not filters
+	Params []string
+}
+
+// Filter is one `field[operator]=value` condition
+type Filter struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+// Sort is one entry of `sort=-name,user_id`
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Query is a parsed listing request
+type Query struct {
+	schema  Schema
+	Filters []Filter
+	Sorts   []Sort
+	Search  string
+}
+
+// Args collects positional SQL arguments
+type Args []interface{}
+
+// Add appends a value and returns its placeholder
+func (a *Args) Add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// parameters of the pagination and of the listing itself, never filters
+var reserved = map[string]bool{"limit": true, "cursor": true, "sort": true, "q": true}
+
+// operators and the SQL they turn into
+var operators = map[string]string{
+	"eq":          "=",
+	"ne":          "<>",
+	"gt":          ">",
+	"gte":         ">=",
+	"lt":          "<",
+	"lte":         "<=",
+	"in":          "= ANY",
+	"contains":    "ILIKE",
+	"starts_with": "ILIKE",
+}
+
+// Parse reads filters, sort and search out of the query string, anything the schema does not know is a 400
+func Parse(c *fiber.Ctx, schema Schema) (Query, error) {
+	return parse(schema, func(visit func(key, value string)) {
+		c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+			visit(string(key), string(value))
+		})
+	})
+}
+
+// ParseValues reads filters, sort and search out of values given the same way as in a query string,
+// name[contains]=x or sort=-name, for callers that don't get them from the URL
+func ParseValues(schema Schema, values url.Values) (Query, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// the same values always make the same SQL
+	sort.Strings(keys)
+
+	return parse(schema, func(visit func(key, value string)) {
+		for _, key := range keys {
+			for _, value := range values[key] {
+				visit(key, value)
+			}
+		}
+	})
+}
+
+func parse(schema Schema, each func(visit func(key, value string))) (Query, error) {
+	q := Query{schema: schema}
+
+	params := make(map[string]bool, len(schema.Params))
+	for _, p := range schema.Params {
+		params[p] = true
+	}
+
+	var err error
+	each(func(k, v string) {
+		if err != nil {
+			return
+		}
+		switch {
+		case k == "sort":
+			q.Sorts, err = parseSort(schema, v)
+		case k == "q":
+			q.Search = strings.TrimSpace(v)
+		case reserved[k] || params[k]:
+		default:
+			var f Filter
+			if f, err = parseFilter(schema, k, v); err == nil {
+				q.Filters = append(q.Filters, f)
+			}
+		}
+	})
+	if err != nil {
+		return q, err
+	}
+
+	if q.Search != "" && len(schema.Search) == 0 {
+		return q, apperrors.BadRequest("search_not_supported", "this listing can not be searched with q")
+	}
+
+	// always end with the key so rows with equal sort values keep a stable order
+	hasKey := false
+	for _, s := range q.Sorts {
+		if s.Field == schema.Key {
+			hasKey = true
+		}
+	}
+	if !hasKey {
+		q.Sorts = append(q.Sorts, Sort{Field: schema.Key})
+	}
+	return q, nil
+}
+
+func parseSort(schema Schema, raw string) ([]Sort, error) {
+	sorts := make([]Sort, 0, 2)
+	seen := make(map[string]bool, 2)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		s := Sort{Field: part}
+		if strings.HasPrefix(part, "-") {
+			s = Sort{Field: part[1:], Desc: true}
+		}
+		field, ok := schema.Fields[s.Field]
+		if !ok || !field.Sortable {
+			return nil, apperrors.BadRequest("invalid_sort", fmt.Sprintf("can not sort by unknown field %q", s.Field))
+		}
+		if seen[s.Field] {
+			return nil, apperrors.BadRequest("invalid_sort", fmt.Sprintf("field %q appears more than once in sort", s.Field))
+		}
+		seen[s.Field] = true
+		sorts = append(sorts, s)
+	}
+	return sorts, nil
+}
+
+func parseFilter(schema Schema, key, value string) (Filter, error) {
+	f := Filter{Field: key, Operator: "eq", Value: value}
+	if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+		f.Field, f.Operator = key[:i], key[i+1:len(key)-1]
+	}
+
+	field, ok := schema.Fields[f.Field]
+	if !ok {
+		return f, apperrors.BadRequest("unknown_parameter", fmt.Sprintf("unknown query parameter %q", key))
+	}
+	allowed := false
+	for _, op := range field.Operators {
+		if op == f.Operator {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return f, apperrors.BadRequest("invalid_operator", fmt.Sprintf("operator %q is not allowed on field %q", f.Operator, f.Field))
+	}
+
+	// make sure the value parses now so a bad one is a 400 and not a database error
+	values := []string{value}
+	if f.Operator == "in" {
+		values = strings.Split(value, ",")
+	}
+	for _, v := range values {
+		if _, err := convert(field, v); err != nil {
+			return f, apperrors.BadRequest("invalid_value", fmt.Sprintf("invalid value %q for field %q", v, f.Field))
+		}
+	}
+	return f, nil
+}
+
+// Where builds the conditions of the filters and the search, joined with AND, or an empty string
+func (q Query) Where(args *Args) string {
+	conditions := make([]string, 0, len(q.Filters)+1)
+
+	for _, f := range q.Filters {
+		field := q.schema.Fields[f.Field]
+		switch {
+		case f.Operator == "contains":
+			conditions = append(conditions, field.Column+" ILIKE "+args.Add("%"+escapeLike(f.Value)+"%"))
+		case f.Operator == "starts_with":
+			conditions = append(conditions, field.Column+" ILIKE "+args.Add(escapeLike(f.Value)+"%"))
+		case f.Operator == "in" && field.CaseInsensitive:
+			conditions = append(conditions, "lower("+field.Column+") = ANY("+args.Add(convertList(field, strings.Split(strings.ToLower(f.Value), ",")))+")")
+		case f.Operator == "in":
+			conditions = append(conditions, field.Column+" = ANY("+args.Add(convertList(field, strings.Split(f.Value, ",")))+")")
+		case field.CaseInsensitive && (f.Operator == "eq" || f.Operator == "ne"):
+			// lower() on both sides, the unique index on lower(email) serves it
+			conditions = append(conditions, "lower("+field.Column+") "+operators[f.Operator]+" lower("+args.Add(f.Value)+")")
+		default:
+			value, _ := convert(field, f.Value)
+			conditions = append(conditions, field.Column+" "+operators[f.Operator]+" "+args.Add(value))
+		}
+	}
+
+	if q.Search != "" {
+		placeholder := args.Add("%" + escapeLike(q.Search) + "%")
+		matches := make([]string, 0, len(q.schema.Search))
+		for _, column := range q.schema.Search {
+			matches = append(matches, column+" ILIKE "+placeholder)
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// OrderBy builds the ORDER BY list, without the keywords
+func (q Query) OrderBy() string {
+	parts := make([]string, 0, len(q.Sorts))
+	for _, s := range q.Sorts {
+		direction := " ASC"
+		if s.Desc {
+			direction = " DESC"
+		}
+		parts = append(parts, q.schema.Fields[s.Field].Column+direction)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Seek builds the keyset condition that starts right after the row a cursor points at,
+// the cursor has to carry one value per sort field, in order
+func (q Query) Seek(after []interface{}, args *Args) (string, error) {
+	if len(after) != len(q.Sorts) {
+		return "", apperrors.BadRequest("invalid_cursor", "cursor does not match the sort of this request")
+	}
+
+	values := make([]string, 0, len(after))
+	for i, s := range q.Sorts {
+		value, err := convert(q.schema.Fields[s.Field], fmt.Sprint(after[i]))
+		if err != nil {
+			return "", apperrors.BadRequest("invalid_cursor", "cursor does not match the sort of this request")
+		}
+		values = append(values, args.Add(value))
+	}
+
+	// (a > x) OR (a = x AND b < y) OR ..., the comparison follows each field's direction
+	branches := make([]string, 0, len(q.Sorts))
+	for i, s := range q.Sorts {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, q.schema.Fields[q.Sorts[j].Field].Column+" = "+values[j])
+		}
+		comparison := " > "
+		if s.Desc {
+			comparison = " < "
+		}
+		terms = append(terms, q.schema.Fields[s.Field].Column+comparison+values[i])
+		branches = append(branches, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", nil
+}
+
+// CursorValues picks the values of the sort fields out of a row, ready for pagination.EncodeCursor
+func (q Query) CursorValues(row map[string]interface{}) []interface{} {
+	values := make([]interface{}, 0, len(q.Sorts))
+	for _, s := range q.Sorts {
+		values = append(values, row[s.Field])
+	}
+	return values
+}
+
+func convert(field Field, raw string) (interface{}, error) {
+	switch field.Type {
+	case Int:
+		return strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	case Time:
+		return time.Parse(time.RFC3339Nano, strings.TrimSpace(raw))
+	default:
+		return raw, nil
+	}
+}
+
+// convertList builds a typed slice so pgx can send it as a postgres array
+func convertList(field Field, raw []string) interface{} {
+	switch field.Type {
+	case Int:
+		values := make([]int64, 0, len(raw))
+		for _, v := range raw {
+			value, _ := convert(field, v)
+			values = append(values, value.(int64))
+		}
+		return values
+	case Time:
+		values := make([]time.Time, 0, len(raw))
+		for _, v := range raw {
+			value, _ := convert(field, v)
+			values = append(values, value.(time.Time))
+		}
+		return values
+	default:
+		return raw
+	}
+}
+
+// escapeLike keeps user input from acting as LIKE wildcards
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
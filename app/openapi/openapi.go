@@ -0,0 +1,393 @@
+package openapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/listing"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/sparse"
+)
+
+// Param is a path, query or header parameter of an operation
+type Param struct {
+	Name string
+	// path, query or header
+	In          string
+	Description string
+	// string, integer, number or boolean, string when empty
+	Type     string
+	Required bool
+}
+
+// File is a file field of a multipart body
+type File struct{}
+
+// Operation is the documentation of one route
+type Operation struct {
+	Method string
+	// Path in fiber syntax, /users/:id
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []Param
+	// Listing adds the filter, sort and search parameters of a listing schema
+	Listing *listing.Schema
+	// Fieldset adds the fields and include parameters of a sparse schema
+	Fieldset *sparse.Schema
+	// Paginated adds ?limit= and ?cursor=
+	Paginated bool
+	// Body is a value of the request body type, nil when there is no body
+	Body interface{}
+	// BodyTypes are the media types of the body, application/json when empty
+	BodyTypes []string
+	// Response is a value of the type the envelope carries in "data"
+	Response interface{}
+	// RawTypes are the media types of operations that answer without the envelope, like exports
+	RawTypes []string
+	// Status of a successful response, 200 when empty
+	Status int
+	// Errors are the error statuses worth documenting
+	Errors []int
+	// Deprecated operations are still served but going away
+	Deprecated bool
+	// Protected operations need a bearer access token, a 401 is documented for them
+	Protected bool
+}
+
+// Info describes the API as a whole
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+	// Server is the URL every path is relative to
+	Server string
+}
+
+// Registry collects the operations of the routes as they are registered
+type Registry struct {
+	operations []Operation
+}
+
+// Add records an operation
+func (r *Registry) Add(op Operation) {
+	r.operations = append(r.operations, op)
+}
+
+// Operations returns every recorded operation in registration order
+func (r *Registry) Operations() []Operation {
+	return r.operations
+}
+
+// Document is an OpenAPI 3 document, only the parts this API uses
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       documentInfo                     `json:"info"`
+	Servers    []server                         `json:"servers,omitempty"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+type documentInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*reply     `json:"responses"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type reply struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes,omitempty"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// bearerAuth names the security scheme of the protected operations
+const bearerAuth = "bearerAuth"
+
+// Document builds the OpenAPI document of every recorded operation
+func (r *Registry) Document(info Info) *Document {
+	g := newGenerator()
+	envelope := g.schemaOf(response.Envelope{})
+	problem := g.schemaOf(response.Problem{})
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    documentInfo{Title: info.Title, Version: info.Version, Description: info.Description},
+		Paths:   make(map[string]map[string]*operation),
+	}
+	if info.Server != "" {
+		doc.Servers = []server{{URL: info.Server}}
+	}
+
+	for _, op := range r.operations {
+		path, pathParams := convertPath(op.Path)
+		o := &operation{
+			OperationID: operationID(op.Method, path),
+			Summary:     op.Summary,
+			Description: op.Description,
+			Tags:        op.Tags,
+			Parameters:  parameters(op, pathParams),
+			Responses:   make(map[string]*reply),
+			Deprecated:  op.Deprecated,
+		}
+
+		if op.Body != nil {
+			types := op.BodyTypes
+			if len(types) == 0 {
+				types = []string{fiber.MIMEApplicationJSON}
+			}
+			o.RequestBody = &requestBody{Required: true, Content: make(map[string]mediaType, len(types))}
+			for _, t := range types {
+				o.RequestBody.Content[t] = mediaType{Schema: g.schemaOf(op.Body)}
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = fiber.StatusOK
+		}
+		success := &reply{Description: http.StatusText(status), Content: make(map[string]mediaType)}
+		if len(op.RawTypes) > 0 {
+			for _, t := range op.RawTypes {
+				success.Content[t] = mediaType{Schema: &Schema{Type: "string"}}
+			}
+		} else {
+			success.Content[fiber.MIMEApplicationJSON] = mediaType{Schema: withData(envelope, g.dataOf(op.Response))}
+		}
+		o.Responses[strconv.Itoa(status)] = success
+
+		statuses := op.Errors
+		if op.Protected {
+			o.Security = []map[string][]string{{bearerAuth: {}}}
+			doc.Components.SecuritySchemes = map[string]securityScheme{bearerAuth: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"}}
+			statuses = append([]int{fiber.StatusUnauthorized}, statuses...)
+		}
+		for _, errStatus := range statuses {
+			o.Responses[strconv.Itoa(errStatus)] = &reply{
+				Description: http.StatusText(errStatus),
+				Content:     map[string]mediaType{fiber.MIMEApplicationJSON: {Schema: envelope}, response.MIMEProblem: {Schema: problem}},
+			}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*operation)
+		}
+		doc.Paths[path][strings.ToLower(op.Method)] = o
+	}
+
+	doc.Components.Schemas = g.schemas
+	return doc
+}
+
+// withData narrows "data" of the envelope down to a list of the response type
+func withData(envelope, data *Schema) *Schema {
+	if data == nil {
+		return envelope
+	}
+	return &Schema{AllOf: []*Schema{envelope, {
+		Type:       "object",
+		Properties: map[string]*Schema{"data": {Type: "array", Items: data}},
+	}}}
+}
+
+// convertPath turns /users/:id into /users/{id} and returns the names of the parameters
+func convertPath(path string) (string, []string) {
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	segments := strings.Split(path, "/")
+	names := make([]string, 0, 1)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			name := strings.TrimSuffix(segment[1:], "?")
+			segments[i] = "{" + name + "}"
+			names = append(names, name)
+		}
+	}
+	return strings.Join(segments, "/"), names
+}
+
+// operationID names an operation after its method and path, GET /users/{id} is getUsersById
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, "{") {
+			segment = "By" + strings.Trim(segment, "{}")
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '_' || r == '-' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+func parameters(op Operation, pathParams []string) []parameter {
+	params := make([]parameter, 0, len(op.Params)+len(pathParams))
+	declared := make(map[string]bool, len(op.Params))
+	for _, p := range op.Params {
+		declared[p.In+":"+p.Name] = true
+		params = append(params, parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Schema:      &Schema{Type: typeOrString(p.Type)},
+		})
+	}
+	// path parameters are always there, even when nobody wrote them down
+	for _, name := range pathParams {
+		if !declared["path:"+name] {
+			params = append(params, parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+
+	if op.Paginated {
+		params = append(params,
+			parameter{Name: "limit", In: "query", Description: "Page size", Schema: &Schema{Type: "integer", Minimum: float(1)}},
+			parameter{Name: "cursor", In: "query", Description: "Where the previous page stopped, taken from meta.next_cursor", Schema: &Schema{Type: "string"}},
+		)
+	}
+	if op.Listing != nil {
+		params = append(params, listingParameters(*op.Listing)...)
+	}
+	if op.Fieldset != nil {
+		params = append(params, parameter{Name: "fields", In: "query", Description: "Comma separated fields to return, all of them when missing: " + strings.Join(op.Fieldset.Fields, ", "), Schema: &Schema{Type: "string"}})
+		if len(op.Fieldset.Relations) > 0 {
+			params = append(params, parameter{Name: "include", In: "query", Description: "Comma separated related resources to embed, dotted for nested ones: " + strings.Join(relationPaths(op.Fieldset.Relations, ""), ", "), Schema: &Schema{Type: "string"}})
+		}
+	}
+	return params
+}
+
+// relationPaths lists every include path of relations, a.b for the relations of a
+func relationPaths(relations map[string]sparse.Relation, prefix string) []string {
+	names := make([]string, 0, len(relations))
+	for name := range relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, prefix+name)
+		paths = append(paths, relationPaths(relations[name].Relations, prefix+name+".")...)
+	}
+	return paths
+}
+
+// operatorDescriptions explain the listing operators
+var operatorDescriptions = map[string]string{
+	"eq":          "equal to",
+	"ne":          "not equal to",
+	"gt":          "greater than",
+	"gte":         "greater than or equal to",
+	"lt":          "less than",
+	"lte":         "less than or equal to",
+	"in":          "one of, comma separated",
+	"contains":    "contains, case insensitive",
+	"starts_with": "starts with, case insensitive",
+}
+
+func listingParameters(schema listing.Schema) []parameter {
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]parameter, 0, len(names)*3+2)
+	sortable := make([]string, 0, len(names))
+	for _, name := range names {
+		field := schema.Fields[name]
+		if field.Sortable {
+			sortable = append(sortable, name)
+		}
+		for _, op := range field.Operators {
+			s := &Schema{Type: "string"}
+			switch {
+			case op == "in":
+			case field.Type == listing.Int:
+				s = &Schema{Type: "integer"}
+			case field.Type == listing.Time:
+				s = &Schema{Type: "string", Format: "date-time"}
+			}
+			key := name + "[" + op + "]"
+			if op == "eq" {
+				key = name
+			}
+			params = append(params, parameter{Name: key, In: "query", Description: name + " " + operatorDescriptions[op], Schema: s})
+		}
+	}
+	if len(sortable) > 0 {
+		params = append(params, parameter{
+			Name:        "sort",
+			In:          "query",
+			Description: "Comma separated fields to sort by, prefix with - for descending: " + strings.Join(sortable, ", "),
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	if len(schema.Search) > 0 {
+		params = append(params, parameter{Name: "q", In: "query", Description: "Search in " + strings.Join(schema.Search, ", "), Schema: &Schema{Type: "string"}})
+	}
+	return params
+}
+
+func typeOrString(t string) string {
+	if t == "" {
+		return "string"
+	}
+	return t
+}
+
+func float(f float64) *float64 {
+	return &f
+}
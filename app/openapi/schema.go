@@ -0,0 +1,230 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// Schema is an OpenAPI schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// generator turns Go types into schemas, structs become components referenced by name
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newGenerator() *generator {
+	return &generator{schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	fileType = reflect.TypeOf(File{})
+)
+
+// schemaOf is the schema of the type of v
+func (g *generator) schemaOf(v interface{}) *Schema {
+	return g.schema(reflect.TypeOf(v))
+}
+
+// dataOf is the schema of one entry of "data", handlers pass a single value or a list
+func (g *generator) dataOf(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return g.schema(t)
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+
+	var s *Schema
+	switch {
+	case t == timeType:
+		s = &Schema{Type: "string", Format: "date-time"}
+	case t == fileType:
+		s = &Schema{Type: "string", Format: "binary"}
+	default:
+		switch t.Kind() {
+		case reflect.Struct:
+			// anonymous structs have no name worth a component, they stay inline
+			if t.Name() == "" {
+				s = &Schema{Type: "object", Properties: make(map[string]*Schema)}
+				g.fields(t, s)
+				break
+			}
+			// references can't be nullable in OpenAPI 3.0
+			return &Schema{Ref: "#/components/schemas/" + g.component(t)}
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				s = &Schema{Type: "string", Format: "byte"}
+			} else {
+				s = &Schema{Type: "array", Items: g.schema(t.Elem())}
+			}
+		case reflect.Map:
+			s = &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+		case reflect.Interface:
+			s = &Schema{}
+		case reflect.String:
+			s = &Schema{Type: "string"}
+		case reflect.Bool:
+			s = &Schema{Type: "boolean"}
+		case reflect.Int64, reflect.Uint64:
+			s = &Schema{Type: "integer", Format: "int64"}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			s = &Schema{Type: "integer"}
+		case reflect.Float32, reflect.Float64:
+			s = &Schema{Type: "number"}
+		default:
+			s = &Schema{}
+		}
+	}
+	s.Nullable = nullable
+	return s
+}
+
+// component builds the schema of a struct once and returns its name
+func (g *generator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := componentName(t)
+	for i := 2; g.schemas[name] != nil; i++ {
+		name = componentName(t) + strconv.Itoa(i)
+	}
+	// registered before the fields so recursive types end in a reference
+	g.names[t] = name
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.schemas[name] = s
+	g.fields(t, s)
+	return name
+}
+
+// fields adds the fields of a struct the way the codec writes them, embedded structs are flattened
+func (g *generator) fields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		name, hidden := fieldName(sf)
+		if hidden {
+			continue
+		}
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			g.fields(sf.Type, s)
+			continue
+		}
+		if name == "" {
+			name = codec.Name(sf.Name)
+		}
+
+		fs := g.schema(sf.Type)
+		if fs.Ref == "" {
+			applyRules(fs, sf)
+		}
+		s.Properties[name] = fs
+		if hasRule(sf.Tag.Get("validate"), "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// fieldName is the json tag name, empty when the field is not renamed by its tag
+func fieldName(sf reflect.StructField) (name string, hidden bool) {
+	tag, ok := sf.Tag.Lookup("json")
+	if !ok {
+		return "", false
+	}
+	name = strings.Split(tag, ",")[0]
+	return name, name == "-"
+}
+
+// applyRules copies the validation rules of a field into its schema
+func applyRules(s *Schema, sf reflect.StructField) {
+	if pattern := sf.Tag.Get("pattern"); pattern != "" {
+		s.Pattern = pattern
+	}
+	for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+		key, arg := strings.TrimSpace(rule), ""
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key, arg = key[:i], key[i+1:]
+		}
+		switch key {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			n := int(limit)
+			switch {
+			case s.Type == "string" && key == "min":
+				s.MinLength = &n
+			case s.Type == "string":
+				s.MaxLength = &n
+			case s.Type == "array" && key == "min":
+				s.MinItems = &n
+			case s.Type == "array":
+				s.MaxItems = &n
+			case key == "min":
+				s.Minimum = &limit
+			default:
+				s.Maximum = &limit
+			}
+		case "oneof":
+			s.Enum = strings.Fields(arg)
+		case "email":
+			s.Format = "email"
+		case "url":
+			s.Format = "uri"
+		}
+	}
+}
+
+func hasRule(rules, rule string) bool {
+	for _, r := range strings.Split(rules, ",") {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// componentName is the exported form of the Go type name, userData becomes UserData
+func componentName(t reflect.Type) string {
+	runes := []rune(t.Name())
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
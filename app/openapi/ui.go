@@ -0,0 +1,184 @@
+package openapi
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UI serves a self contained docs page for the document at specURL, no CDN involved,
+// every operation can be tried right from the page
+func UI(title, specURL string) fiber.Handler {
+	var page strings.Builder
+	_ = uiTemplate.Execute(&page, struct{ Title, SpecURL string }{title, specURL})
+	html := page.String()
+
+	return func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(html)
+	}
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 0; color: #1f2328; background: #f6f8fa; }
+header { background: #24292f; color: #fff; padding: 16px 32px; }
+header h1 { margin: 0; font-size: 20px; }
+header a { color: #9ecbff; font-size: 13px; }
+.auth { max-width: 1000px; margin: 16px auto 0; padding: 0 16px; font-size: 13px; }
+.auth input { width: 420px; }
+main { max-width: 1000px; margin: 24px auto; padding: 0 16px; }
+h2 { font-size: 16px; margin: 28px 0 8px; text-transform: capitalize; }
+details { background: #fff; border: 1px solid #d0d7de; border-radius: 6px; margin-bottom: 8px; }
+summary { cursor: pointer; padding: 10px 12px; font-family: monospace; font-size: 14px; }
+summary span.summary { font-family: sans-serif; color: #57606a; margin-left: 12px; }
+.method { display: inline-block; min-width: 60px; font-weight: bold; }
+.get { color: #0969da; } .post { color: #1a7f37; } .put { color: #9a6700; } .patch { color: #8250df; } .delete { color: #cf222e; }
+.body { padding: 0 12px 12px; border-top: 1px solid #d0d7de; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; margin: 8px 0; }
+td, th { text-align: left; padding: 4px 8px; border-bottom: 1px solid #eaeef2; vertical-align: top; }
+input, textarea, select { font-family: monospace; font-size: 13px; width: 100%; box-sizing: border-box; }
+textarea { min-height: 120px; }
+button { margin-top: 8px; padding: 6px 14px; cursor: pointer; }
+pre { background: #f6f8fa; padding: 8px; overflow: auto; font-size: 12px; max-height: 400px; }
+</style>
+</head>
+<body>
+<header><h1>{{.Title}}</h1><a href="{{.SpecURL}}">{{.SpecURL}}</a></header>
+<p class="auth">Access token for the protected operations: <input id="token" placeholder="from POST /auth/login"></p>
+<main id="ops">Loading…</main>
+<script>
+(function () {
+  var specURL = {{.SpecURL}};
+  var spec;
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) { node.setAttribute(k, attrs[k]); });
+    (children || []).forEach(function (c) { node.appendChild(typeof c === "string" ? document.createTextNode(c) : c); });
+    return node;
+  }
+
+  function resolve(schema) {
+    if (schema && schema.$ref) {
+      return spec.components.schemas[schema.$ref.split("/").pop()];
+    }
+    return schema || {};
+  }
+
+  // example builds a sample value out of a schema, for the request body editor
+  function example(schema, depth) {
+    schema = resolve(schema);
+    if (depth > 5) return null;
+    if (schema.allOf) {
+      var merged = {};
+      schema.allOf.forEach(function (s) { Object.assign(merged, example(s, depth + 1)); });
+      return merged;
+    }
+    if (schema.enum) return schema.enum[0];
+    switch (schema.type) {
+      case "object":
+        var out = {};
+        Object.keys(schema.properties || {}).forEach(function (k) { out[k] = example(schema.properties[k], depth + 1); });
+        return out;
+      case "array": return [example(schema.items, depth + 1)];
+      case "integer": case "number": return 0;
+      case "boolean": return false;
+      case "string": return schema.format === "date-time" ? new Date().toISOString() : "";
+    }
+    return null;
+  }
+
+  function operation(path, method, op) {
+    var inputs = {};
+    var rows = (op.parameters || []).map(function (p) {
+      var input = el("input", { placeholder: p.schema.type + (p.required ? " (required)" : "") });
+      inputs[p.in + ":" + p.name] = input;
+      return el("tr", {}, [el("td", {}, [p.name]), el("td", {}, [p.in]), el("td", {}, [p.description || ""]), el("td", {}, [input])]);
+    });
+
+    var body = el("div", { class: "body" }, []);
+    if (rows.length) {
+      body.appendChild(el("table", {}, [el("tr", {}, [el("th", {}, ["Parameter"]), el("th", {}, ["In"]), el("th", {}, ["Description"]), el("th", {}, ["Value"])])].concat(rows)));
+    }
+
+    var editor, fileInput, contentType;
+    if (op.requestBody) {
+      contentType = Object.keys(op.requestBody.content)[0];
+      if (contentType === "multipart/form-data") {
+        fileInput = el("input", { type: "file" });
+        body.appendChild(el("p", {}, ["File: "]));
+        body.appendChild(fileInput);
+      } else {
+        editor = el("textarea", {}, []);
+        editor.value = JSON.stringify(example(op.requestBody.content[contentType].schema, 0), null, 2);
+        body.appendChild(el("p", {}, ["Body (" + contentType + ")"]));
+        body.appendChild(editor);
+      }
+    }
+
+    var statuses = Object.keys(op.responses).map(function (s) { return s + " " + op.responses[s].description; }).join(", ");
+    body.appendChild(el("p", {}, ["Responses: " + statuses]));
+
+    var output = el("pre", {}, []);
+    var send = el("button", {}, ["Send"]);
+    send.onclick = function () {
+      var url = path, query = [], headers = {};
+      (op.parameters || []).forEach(function (p) {
+        var value = inputs[p.in + ":" + p.name].value;
+        if (value === "") return;
+        if (p.in === "path") url = url.replace("{" + p.name + "}", encodeURIComponent(value));
+        if (p.in === "query") query.push(encodeURIComponent(p.name) + "=" + encodeURIComponent(value));
+        if (p.in === "header") headers[p.name] = value;
+      });
+      var token = document.getElementById("token").value;
+      if (op.security && token) headers["Authorization"] = "Bearer " + token;
+      var server = (spec.servers && spec.servers[0] && spec.servers[0].url) || "";
+      var init = { method: method.toUpperCase(), headers: headers };
+      if (editor) { headers["Content-Type"] = contentType; init.body = editor.value; }
+      if (fileInput && fileInput.files[0]) { init.body = new FormData(); init.body.append("file", fileInput.files[0]); }
+      output.textContent = "…";
+      fetch(server + url + (query.length ? "?" + query.join("&") : ""), init).then(function (res) {
+        return res.text().then(function (text) {
+          try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (e) {}
+          output.textContent = res.status + " " + res.statusText + "\n\n" + text;
+        });
+      }).catch(function (e) { output.textContent = String(e); });
+    };
+    body.appendChild(send);
+    body.appendChild(output);
+
+    return el("details", {}, [
+      el("summary", {}, [el("span", { class: "method " + method }, [method.toUpperCase()]), path, el("span", { class: "summary" }, [op.summary || ""])]),
+      body
+    ]);
+  }
+
+  fetch(specURL).then(function (res) { return res.json(); }).then(function (s) {
+    spec = s;
+    var root = document.getElementById("ops");
+    root.textContent = "";
+    var groups = {};
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      Object.keys(spec.paths[path]).forEach(function (method) {
+        var op = spec.paths[path][method];
+        var tag = (op.tags && op.tags[0]) || "default";
+        (groups[tag] = groups[tag] || []).push(operation(path, method, op));
+      });
+    });
+    Object.keys(groups).sort().forEach(function (tag) {
+      root.appendChild(el("h2", {}, [tag]));
+      groups[tag].forEach(function (node) { root.appendChild(node); });
+    });
+  }).catch(function (e) { document.getElementById("ops").textContent = "Could not load " + specURL + ": " + e; });
+})();
+</script>
+</body>
+</html>
+`))
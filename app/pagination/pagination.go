@@ -0,0 +1,127 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/valyala/fasthttp"
+)
+
+// Params of a keyset paginated request
+type Params struct {
+	// How many rows the page holds
+	Limit int
+	// Values of the last row of the previous page, empty on the first page,
+	// numbers come back as jsoniter.Number so big ids keep their precision
+	After []interface{}
+}
+
+// Meta is the "meta" part of a paginated response
+type Meta struct {
+	Limit      int
+	NextCursor string `json:",omitempty"`
+	HasMore    bool
+}
+
+// Links is the "links" part of a paginated response
+type Links struct {
+	Self  string
+	First string
+	Next  string `json:",omitempty"`
+}
+
+// cursors are only ever decoded by us, keep numbers as they were written
+var cursorCodec = jsoniter.Config{UseNumber: true}.Froze()
+
+// FromRequest reads the limit and cursor query parameters
+func FromRequest(c *fiber.Ctx, defaultLimit, maxLimit int) (Params, error) {
+	p := Params{Limit: defaultLimit}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return p, apperrors.BadRequest("invalid_limit", "limit must be a positive integer")
+		}
+		p.Limit = limit
+	}
+	// asking for more than allowed just gets the biggest page we hand out
+	if maxLimit > 0 && p.Limit > maxLimit {
+		p.Limit = maxLimit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		after, err := DecodeCursor(raw)
+		if err != nil {
+			return p, apperrors.BadRequest("invalid_cursor", "cursor is invalid, use the next_cursor of a previous page")
+		}
+		p.After = after
+	}
+	return p, nil
+}
+
+// EncodeCursor turns the values of the last row of a page into an opaque cursor
+func EncodeCursor(values ...interface{}) string {
+	raw, err := cursorCodec.Marshal(values)
+	if err != nil {
+		panic(fmt.Sprintf("Error encoding pagination cursor: %s", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor reverses EncodeCursor
+func DecodeCursor(cursor string) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	if err := cursorCodec.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty cursor")
+	}
+	return values, nil
+}
+
+// Build the metadata and links of a page and set the matching RFC 8288 Link header,
+// next is the cursor of the last row and is only used when there are more rows
+func Build(c *fiber.Ctx, p Params, hasMore bool, next string) (Meta, Links) {
+	meta := Meta{Limit: p.Limit, HasMore: hasMore}
+	links := Links{
+		Self:  c.BaseURL() + c.OriginalURL(),
+		First: pageURL(c, ""),
+	}
+	linkHeader := fmt.Sprintf(`<%s>; rel="first"`, links.First)
+
+	if hasMore {
+		meta.NextCursor = next
+		links.Next = pageURL(c, next)
+		linkHeader = fmt.Sprintf(`<%s>; rel="next", %s`, links.Next, linkHeader)
+	}
+
+	c.Set(fiber.HeaderLink, linkHeader)
+	return meta, links
+}
+
+// pageURL is the current URL with every query parameter kept but the cursor
+func pageURL(c *fiber.Ctx, cursor string) string {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	c.Context().QueryArgs().CopyTo(args)
+	args.Del("cursor")
+	if cursor != "" {
+		args.Set("cursor", cursor)
+	}
+
+	url := c.BaseURL() + c.Path()
+	if args.Len() > 0 {
+		url += "?" + string(args.QueryString())
+	}
+	return url
+}
@@ -0,0 +1,383 @@
+package patch
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+)
+
+// Media types of the supported patch formats
+const (
+	MIMEMergePatch = "application/merge-patch+json"
+	MIMEJSONPatch  = "application/json-patch+json"
+)
+
+// AcceptPatch is the value of the Accept-Patch header of patchable resources
+const AcceptPatch = MIMEMergePatch + ", " + MIMEJSONPatch
+
+// documents are only handled here, keep numbers exactly as they came in
+var json = jsoniter.Config{UseNumber: true}.Froze()
+
+// patched documents must not grow members the resource does not have
+var strict = jsoniter.Config{DisallowUnknownFields: true}.Froze()
+
+// MediaType checks that a content type names a supported patch format, anything else is a 415
+func MediaType(contentType string) (string, error) {
+	mediaType := strings.TrimSpace(strings.ToLower(strings.Split(contentType, ";")[0]))
+	if mediaType != MIMEMergePatch && mediaType != MIMEJSONPatch {
+		return "", apperrors.New(fiber.StatusUnsupportedMediaType, "unsupported_patch_format",
+			fmt.Sprintf("PATCH bodies must be %s or %s.", MIMEMergePatch, MIMEJSONPatch))
+	}
+	return mediaType, nil
+}
+
+// Apply patches doc with the patch format the content type names
+func Apply(contentType string, doc, patch []byte) ([]byte, error) {
+	mediaType, err := MediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType == MIMEMergePatch {
+		return MergePatch(doc, patch)
+	}
+	return JSONPatch(doc, patch)
+}
+
+// Decode reads a patched document back into out, members out does not know are a 422
+func Decode(doc []byte, out interface{}) error {
+	if err := strict.Unmarshal(doc, out); err != nil {
+		return apperrors.Wrap(err, fiber.StatusUnprocessableEntity, "invalid_patch_result", "The patched document is not a valid resource.")
+	}
+	return nil
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch: objects are merged recursively,
+// null removes a member and anything else replaces the target
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, invalidPatch(err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergeValue(t[key], value)
+	}
+	return t
+}
+
+// operation is one entry of an RFC 6902 JSON Patch
+type operation struct {
+	Op   string  `json:"op"`
+	Path *string `json:"path"`
+	From *string `json:"from"`
+	// Value stays raw until it is needed, an explicit null has to be told apart from no value at all
+	Value jsoniter.RawMessage `json:"value"`
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch, all operations or none
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, invalidPatch(err)
+	}
+
+	var err error
+	for i, op := range ops {
+		var value interface{}
+		if op.Path == nil {
+			return nil, invalidPatch(fmt.Errorf("operation %d has no path", i))
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, invalidPatch(fmt.Errorf("operation %d (%s) has no value", i, op.Op))
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, invalidPatch(err)
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, invalidPatch(fmt.Errorf("operation %d (%s) has no from", i, op.Op))
+			}
+		case "remove":
+		default:
+			return nil, invalidPatch(fmt.Errorf("operation %d has an unknown op %q", i, op.Op))
+		}
+
+		switch op.Op {
+		case "add":
+			target, err = add(target, *op.Path, value)
+		case "remove":
+			target, _, err = remove(target, *op.Path)
+		case "replace":
+			if target, _, err = remove(target, *op.Path); err == nil {
+				target, err = add(target, *op.Path, value)
+			}
+		case "move":
+			if *op.From == *op.Path {
+				break
+			}
+			if strings.HasPrefix(*op.Path, *op.From+"/") {
+				return nil, unprocessable(fmt.Sprintf("operation %d moves %s into one of its children", i, *op.From))
+			}
+			if target, value, err = remove(target, *op.From); err == nil {
+				target, err = add(target, *op.Path, value)
+			}
+		case "copy":
+			if value, err = get(target, *op.From); err == nil {
+				target, err = add(target, *op.Path, deepCopy(value))
+			}
+		case "test":
+			var current interface{}
+			if current, err = get(target, *op.Path); err == nil && !equal(current, value) {
+				return nil, apperrors.New(fiber.StatusConflict, "patch_test_failed", fmt.Sprintf("operation %d: test of %s failed", i, *op.Path))
+			}
+		}
+		if err != nil {
+			return nil, unprocessable(fmt.Sprintf("operation %d (%s): %s", i, op.Op, err))
+		}
+	}
+	return json.Marshal(target)
+}
+
+// pointer splits an RFC 6901 JSON pointer into unescaped tokens
+func pointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(doc interface{}, path string) (interface{}, error) {
+	tokens, err := pointer(path)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", path)
+			}
+			current = value
+		case []interface{}:
+			i, err := index(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path %s does not exist", path)
+		}
+	}
+	return current, nil
+}
+
+// add sets value at path, it returns the new root because adding at "" replaces the whole document
+func add(doc interface{}, path string, value interface{}) (interface{}, error) {
+	tokens, err := pointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, parentPath(tokens))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := index(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return replaceAt(doc, tokens[:len(tokens)-1], node)
+	default:
+		return nil, fmt.Errorf("parent of %s is not an object or an array", path)
+	}
+	return doc, nil
+}
+
+// remove deletes what is at path and returns the new root and the removed value
+func remove(doc interface{}, path string) (interface{}, interface{}, error) {
+	tokens, err := pointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := get(doc, parentPath(tokens))
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %s does not exist", path)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		i, err := index(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		root, err := replaceAt(doc, tokens[:len(tokens)-1], node)
+		return root, value, err
+	}
+	return nil, nil, fmt.Errorf("path %s does not exist", path)
+}
+
+// replaceAt swaps the value at tokens, slices can't be grown in place so arrays go through here
+func replaceAt(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, parentPath(tokens))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := index(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
+
+func parentPath(tokens []string) string {
+	parent := ""
+	for _, token := range tokens[:len(tokens)-1] {
+		parent += "/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+	}
+	return parent
+}
+
+// index parses an array index, "-" (the end) is only allowed when adding
+func index(token string, length int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > length || (!adding && i == length) {
+		return 0, fmt.Errorf("array index %d is out of bounds", i)
+	}
+	return i, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	raw, _ := json.Marshal(value)
+	var out interface{}
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+// number is json.Number, what UseNumber decodes numbers into
+type number interface {
+	Float64() (float64, error)
+}
+
+func equal(a, b interface{}) bool {
+	// numbers are compared by value, 1 and 1.0 are the same
+	if na, ok := a.(number); ok {
+		if nb, ok := b.(number); ok {
+			fa, errA := na.Float64()
+			fb, errB := nb.Float64()
+			return errA == nil && errB == nil && fa == fb
+		}
+	}
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for key, value := range va {
+			other, ok := vb[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !equal(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func invalidPatch(err error) *apperrors.AppError {
+	return apperrors.Wrap(err, fiber.StatusBadRequest, "invalid_patch", "The patch document could not be parsed.")
+}
+
+func unprocessable(message string) *apperrors.AppError {
+	return apperrors.New(fiber.StatusUnprocessableEntity, "patch_not_applicable", message)
+}
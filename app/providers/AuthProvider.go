@@ -1,26 +1,73 @@
 package providers
 
-type Config struct {
-	Username string
-}
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+)
+
+var tokens *auth.Tokens
 
-type Provider struct {
-	Config Config
+var revocations *auth.Revocations
+
+// AuthProvider issues and verifies the access tokens, nil while auth is disabled
+func AuthProvider() *auth.Tokens {
+	return tokens
 }
 
-var authP Provider
+// SetAuthProvider loads the signing keys of config, after it Authenticate requires tokens
+func SetAuthProvider(config auth.Config) error {
+	t, err := auth.NewTokens(config)
+	if err != nil {
+		return err
+	}
+	tokens = t
+	return nil
+}
 
-func AuthProvider() *Provider {
-	return &authP
+// SetRevocationProvider makes Authenticate refuse the access tokens of revoked sessions,
+// sessions live in the database so it stays unset without one
+func SetRevocationProvider(r *auth.Revocations) {
+	revocations = r
 }
 
-func SetAuthProvider(config ...Config) {
-	var cfg Config
-	if len(config) > 0 {
-		cfg = config[0]
+// Authenticate requires a valid "Authorization: Bearer <token>" and puts its claims in the locals
+// under auth.ClaimsKey, read them with auth.ClaimsFrom(c.Context()).
+// It lets everything through while auth is disabled
+func Authenticate(c *fiber.Ctx) error {
+	if tokens == nil {
+		return c.Next()
+	}
+
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		// RFC 6750, a request without credentials gets the scheme but no error
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="api"`)
+		return apperrors.New(fiber.StatusUnauthorized, "missing_token", "This route needs an access token in the Authorization header.")
+	}
+
+	claims, err := tokens.Parse(strings.TrimSpace(header[7:]), time.Now())
+	if err != nil {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="api", error="invalid_token"`)
+		if err == auth.ErrExpiredToken {
+			return apperrors.New(fiber.StatusUnauthorized, "token_expired", "The access token has expired.")
+		}
+		return apperrors.New(fiber.StatusUnauthorized, "invalid_token", "The access token is invalid.")
 	}
-	if cfg.Username == "" {
-		cfg.Username = "username"
+	// the token outlives a logout or a replayed refresh token, its session doesn't
+	if revocations != nil && claims.SessionID != "" {
+		revoked, err := revocations.Revoked(c.Context(), claims.SessionID, time.Now())
+		if err != nil {
+			return err
+		}
+		if revoked {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="api", error="invalid_token"`)
+			return apperrors.New(fiber.StatusUnauthorized, "session_revoked", "The session of the access token has ended, log in again.")
+		}
 	}
-	authP = Provider{Config: cfg}
+	c.Locals(auth.ClaimsKey, claims)
+	return c.Next()
 }
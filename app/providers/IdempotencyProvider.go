@@ -0,0 +1,22 @@
+package providers
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+var idempotent fiber.Handler
+
+// SetIdempotencyProvider sets the Idempotency-Key middleware the protected routes run, after Authenticate
+// so the stored responses belong to the user who made the request
+func SetIdempotencyProvider(handler fiber.Handler) {
+	idempotent = handler
+}
+
+// Idempotent replays the stored response to retried requests carrying an Idempotency-Key,
+// it lets everything through while idempotency is disabled
+func Idempotent(c *fiber.Ctx) error {
+	if idempotent == nil {
+		return c.Next()
+	}
+	return idempotent(c)
+}
@@ -0,0 +1,317 @@
+package response
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// The other formats are written from the JSON of the envelope, so every format
+// names its fields the same way and follows the codec configuration.
+
+// member is one key of an object, objects are kept in order
+type member struct {
+	key   string
+	value interface{}
+}
+
+type object []member
+
+// toTree decodes JSON into objects, []interface{}, json.Number, string, bool and nil
+func toTree(e Envelope) (interface{}, error) {
+	raw, err := codec.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	iter := jsoniter.ParseBytes(jsoniter.ConfigDefault, raw)
+	tree := readTree(iter)
+	if iter.Error != nil {
+		return nil, iter.Error
+	}
+	return tree, nil
+}
+
+func readTree(iter *jsoniter.Iterator) interface{} {
+	switch iter.WhatIsNext() {
+	case jsoniter.ObjectValue:
+		obj := object{}
+		for key := iter.ReadObject(); key != ""; key = iter.ReadObject() {
+			obj = append(obj, member{key: key, value: readTree(iter)})
+		}
+		return obj
+	case jsoniter.ArrayValue:
+		arr := make([]interface{}, 0)
+		for iter.ReadArray() {
+			arr = append(arr, readTree(iter))
+		}
+		return arr
+	case jsoniter.NumberValue:
+		return iter.ReadNumber()
+	case jsoniter.StringValue:
+		return iter.ReadString()
+	case jsoniter.BoolValue:
+		return iter.ReadBool()
+	default:
+		iter.ReadNil()
+		return nil
+	}
+}
+
+// encodeXML writes the envelope as <response>, list entries become <item> elements
+func encodeXML(e Envelope) ([]byte, error) {
+	tree, err := toTree(e)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(xml.Header)
+	writeXML(buf, "response", tree)
+	return buf.Bytes(), nil
+}
+
+func writeXML(buf *bytes.Buffer, name string, value interface{}) {
+	name = xmlName(name)
+	if value == nil {
+		buf.WriteString("<" + name + "/>")
+		return
+	}
+	buf.WriteString("<" + name + ">")
+	switch v := value.(type) {
+	case object:
+		for _, m := range v {
+			writeXML(buf, m.key, m.value)
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXML(buf, "item", item)
+		}
+	default:
+		_ = xml.EscapeText(buf, []byte(scalar(v)))
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+// xmlName turns a key into a valid element name
+func xmlName(key string) string {
+	name := []rune(key)
+	for i, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || !unicode.IsLetter(name[0]) && name[0] != '_' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// encodeCSV writes the data of the envelope, one row per entry and one column per key
+func encodeCSV(e Envelope) ([]byte, error) {
+	tree, err := toTree(e)
+	if err != nil {
+		return nil, err
+	}
+	var data []interface{}
+	for _, m := range tree.(object) {
+		if m.key == "data" {
+			data, _ = m.value.([]interface{})
+		}
+	}
+
+	// columns are every key seen, in the order they first show up
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, row := range data {
+		obj, ok := row.(object)
+		if !ok {
+			columns, seen = []string{"value"}, nil
+			break
+		}
+		for _, m := range obj {
+			if !seen[m.key] {
+				seen[m.key] = true
+				columns = append(columns, m.key)
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if len(columns) > 0 {
+		_ = w.Write(columns)
+	}
+	for _, row := range data {
+		record := make([]string, len(columns))
+		if obj, ok := row.(object); ok && seen != nil {
+			for _, m := range obj {
+				for i, column := range columns {
+					if column == m.key {
+						record[i] = cell(m.value)
+					}
+				}
+			}
+		} else {
+			record[0] = cell(row)
+		}
+		_ = w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// cell is how a value is shown in a CSV column, nested values are written as JSON
+func cell(value interface{}) string {
+	switch value.(type) {
+	case object, []interface{}:
+		raw, _ := codec.Marshal(fromTree(value))
+		return string(raw)
+	}
+	return scalar(value)
+}
+
+func scalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return string(v)
+	}
+	return ""
+}
+
+// fromTree turns ordered objects back into something the codec can marshal
+func fromTree(value interface{}) interface{} {
+	switch v := value.(type) {
+	case object:
+		out := make(map[string]interface{}, len(v))
+		for _, m := range v {
+			out[m.key] = fromTree(m.value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = fromTree(v[i])
+		}
+		return out
+	}
+	return value
+}
+
+// encodeMsgPack writes the envelope as MessagePack
+func encodeMsgPack(e Envelope) ([]byte, error) {
+	tree, err := toTree(e)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	writeMsgPack(buf, tree)
+	return buf.Bytes(), nil
+}
+
+func writeMsgPack(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		writeMsgPackNumber(buf, string(v))
+	case string:
+		writeMsgPackHeader(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgPackHeader(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			writeMsgPack(buf, item)
+		}
+	case object:
+		writeMsgPackHeader(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, m := range v {
+			writeMsgPack(buf, m.key)
+			writeMsgPack(buf, m.value)
+		}
+	}
+}
+
+// writeMsgPackHeader writes the type and length of a string, array or map,
+// fix is the prefix of the short form and len8 is 0 when the type has no 8 bit form
+func writeMsgPackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, len8, len16, len32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case len8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(len8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(len16)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(len32)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgPackNumber(buf *bytes.Buffer, number string) {
+	if !strings.ContainsAny(number, ".eE") {
+		if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+			writeMsgPackInt(buf, i)
+			return
+		}
+		if u, err := strconv.ParseUint(number, 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			_ = binary.Write(buf, binary.BigEndian, u)
+			return
+		}
+	}
+	f, _ := strconv.ParseFloat(number, 64)
+	buf.WriteByte(0xcb)
+	_ = binary.Write(buf, binary.BigEndian, f)
+}
+
+// writeMsgPackInt uses the smallest form that holds i
+func writeMsgPackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		_ = binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		_ = binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= math.MinInt8 && i < 0:
+		buf.WriteByte(0xd0)
+		_ = binary.Write(buf, binary.BigEndian, int8(i))
+	case i >= math.MinInt16 && i < 0:
+		buf.WriteByte(0xd1)
+		_ = binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i < 0:
+		buf.WriteByte(0xd2)
+		_ = binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, i)
+	}
+}
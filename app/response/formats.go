@@ -0,0 +1,209 @@
+package response
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// Names of the formats responses can be written in
+const (
+	JSON    = "json"
+	XML     = "xml"
+	MsgPack = "msgpack"
+	CSV     = "csv"
+)
+
+// Config of the response formats
+type Config struct {
+	// Formats offered to clients through Accept, in order of preference,
+	// error responses fall back to JSON when the client accepts none of them
+	Formats []string
+	// Errors is envelope or problem, envelope errors still go out as problems
+	// to clients that ask for application/problem+json
+	Errors string
+	// ProblemTypeBase is prefixed to the code of an error to make the type of its problem,
+	// about:blank is used when empty
+	ProblemTypeBase string
+	// RequestIDHeader is where problems take their request_id from, follows the request ID middleware
+	RequestIDHeader string
+}
+
+// ConfigDefault offers every format, JSON first, and sends errors in the envelope
+var ConfigDefault = Config{
+	Formats:         []string{JSON, XML, MsgPack, CSV},
+	Errors:          ErrorsEnvelope,
+	RequestIDHeader: fiber.HeaderXRequestID,
+}
+
+// format is one way of writing an envelope
+type format struct {
+	// media types clients may ask for, the first one is sent as Content-Type
+	mediaTypes []string
+	// collections only formats can't carry the envelope, only its data
+	collectionsOnly bool
+	encode          func(Envelope) ([]byte, error)
+}
+
+var formats = map[string]format{
+	JSON:    {mediaTypes: []string{fiber.MIMEApplicationJSON}, encode: encodeJSON},
+	XML:     {mediaTypes: []string{fiber.MIMEApplicationXMLCharsetUTF8, fiber.MIMETextXML}, encode: encodeXML},
+	MsgPack: {mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, encode: encodeMsgPack},
+	CSV:     {mediaTypes: []string{"text/csv; charset=utf-8"}, collectionsOnly: true, encode: encodeCSV},
+}
+
+var (
+	enabled         = ConfigDefault.Formats
+	errorStyle      = ConfigDefault.Errors
+	problemTypeBase = ConfigDefault.ProblemTypeBase
+	requestIDHeader = ConfigDefault.RequestIDHeader
+)
+
+// Configure sets the offered formats, call it once at startup before the server starts listening
+func Configure(config Config) error {
+	if len(config.Formats) == 0 {
+		config.Formats = ConfigDefault.Formats
+	}
+	for i, name := range config.Formats {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := formats[name]; !ok {
+			return fmt.Errorf("unknown response format %q, use %s, %s, %s or %s", name, JSON, XML, MsgPack, CSV)
+		}
+		config.Formats[i] = name
+	}
+
+	switch config.Errors {
+	case "":
+		config.Errors = ConfigDefault.Errors
+	case ErrorsEnvelope, ErrorsProblem:
+	default:
+		return fmt.Errorf("unknown error style %q, use %s or %s", config.Errors, ErrorsEnvelope, ErrorsProblem)
+	}
+	if config.RequestIDHeader == "" {
+		config.RequestIDHeader = ConfigDefault.RequestIDHeader
+	}
+
+	enabled = config.Formats
+	errorStyle = config.Errors
+	problemTypeBase = config.ProblemTypeBase
+	requestIDHeader = config.RequestIDHeader
+	return nil
+}
+
+// Negotiate picks the enabled format the Accept header prefers, collections only formats
+// are only considered when collection is true. ok is false when the client accepts none of them.
+func Negotiate(c *fiber.Ctx, collection bool) (name string, ok bool) {
+	ranges := parseAccept(c.Get(fiber.HeaderAccept))
+
+	bestQ := 0.0
+	for _, candidate := range enabled {
+		f := formats[candidate]
+		if f.collectionsOnly && !collection {
+			continue
+		}
+		if len(ranges) == 0 {
+			return candidate, true
+		}
+		for _, mediaType := range f.mediaTypes {
+			// ties go to the format listed first in the config
+			if q := quality(ranges, mediaType); q > bestQ {
+				name, bestQ = candidate, q
+			}
+		}
+	}
+	return name, bestQ > 0
+}
+
+// Accepts returns the offer the Accept header prefers, "" when it accepts none of them,
+// a request without Accept takes the first offer. Unlike fiber's Accepts it honours q values.
+func Accepts(c *fiber.Ctx, offers ...string) string {
+	ranges := parseAccept(c.Get(fiber.HeaderAccept))
+	if len(ranges) == 0 && len(offers) > 0 {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// notAcceptable is the 406 of a request that accepts none of the enabled formats
+func notAcceptable() error {
+	offered := make([]string, 0, len(enabled))
+	for _, name := range enabled {
+		offered = append(offered, mediaType(formats[name].mediaTypes[0]))
+	}
+	return apperrors.New(fiber.StatusNotAcceptable, "not_acceptable", "This resource is available as "+strings.Join(offered, ", ")+".")
+}
+
+// mediaRange is one entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []mediaRange {
+	ranges := make([]mediaRange, 0, 4)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mt, q: q})
+	}
+	return ranges
+}
+
+// quality is the q the most specific matching range gives to a media type, 0 when none matches
+func quality(ranges []mediaRange, offer string) float64 {
+	offer = mediaType(offer)
+	slash := strings.IndexByte(offer, '/')
+	if slash < 0 {
+		slash = len(offer)
+	}
+	specificity, q := -1, 0.0
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == offer:
+			s = 2
+		case r.mediaType == offer[:slash]+"/*":
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			specificity, q = s, r.q
+		}
+	}
+	return q
+}
+
+// mediaType strips the parameters of a content type
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(contentType)
+}
+
+func encodeJSON(e Envelope) ([]byte, error) {
+	return codec.Marshal(e)
+}
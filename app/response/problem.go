@@ -0,0 +1,100 @@
+package response
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// MIMEProblem is the media type of RFC 7807 error responses
+const MIMEProblem = "application/problem+json"
+
+// Styles of the error responses
+const (
+	// ErrorsEnvelope sends errors in the same {success,status,message,data} envelope as everything else
+	ErrorsEnvelope = "envelope"
+	// ErrorsProblem sends errors as application/problem+json
+	ErrorsProblem = "problem"
+)
+
+// Problem is an RFC 7807 problem details object, code, request_id, errors and debug are extension members
+type Problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Errors    interface{} `json:"errors,omitempty"`
+	Debug     string      `json:"debug,omitempty"`
+}
+
+// wantsProblem tells whether an error response goes out as a problem, always in problem style,
+// otherwise when the client asks for application/problem+json at least as much as for application/json
+func wantsProblem(c *fiber.Ctx) bool {
+	if errorStyle == ErrorsProblem {
+		return true
+	}
+	ranges := parseAccept(c.Get(fiber.HeaderAccept))
+	for _, r := range ranges {
+		if r.mediaType == MIMEProblem && r.q > 0 {
+			return r.q >= quality(ranges, fiber.MIMEApplicationJSON)
+		}
+	}
+	return false
+}
+
+// sendProblem writes an error envelope as a problem, the data of the envelope becomes "errors"
+func sendProblem(c *fiber.Ctx, e Envelope, data interface{}) error {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Message,
+		Instance:  c.OriginalURL(),
+		Code:      e.Code,
+		RequestID: requestID(c),
+		Debug:     e.Debug,
+	}
+	// without a base every problem is about:blank and the code tells them apart
+	if problemTypeBase != "" && e.Code != "" {
+		p.Type = problemTypeBase + e.Code
+	}
+	if !isEmpty(data) {
+		p.Errors = data
+	}
+
+	output, err := codec.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Error converting the response to a problem: %w", err)
+	}
+	c.Status(e.Status)
+	c.Set(fiber.HeaderContentType, MIMEProblem)
+	return c.Send(output)
+}
+
+// requestID is the id the request ID middleware answered with, or the one the client sent
+func requestID(c *fiber.Ctx) string {
+	if id := c.Response().Header.Peek(requestIDHeader); len(id) > 0 {
+		return string(id)
+	}
+	return c.Get(requestIDHeader)
+}
+
+func isEmpty(data interface{}) bool {
+	if data == nil {
+		return true
+	}
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
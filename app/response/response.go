@@ -0,0 +1,140 @@
+package response
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/pagination"
+)
+
+// Envelope is the standard {success,status,message,data} response, with optional meta and links,
+// the fields are tagged so the naming and omit empty policies of the codec leave them alone
+type Envelope struct {
+	Success bool                   `json:"success"`
+	Status  int                    `json:"status"`
+	Code    string                 `json:"code,omitempty"`
+	Message string                 `json:"message"`
+	Data    interface{}            `json:"data"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Links   map[string]string      `json:"links,omitempty"`
+	Debug   string                 `json:"debug,omitempty"`
+}
+
+// Option adds optional parts to an envelope
+type Option func(*Envelope)
+
+// WithMeta sets one entry of "meta", the snake_case key follows the naming strategy of the codec
+func WithMeta(key string, value interface{}) Option {
+	return func(e *Envelope) {
+		if e.Meta == nil {
+			e.Meta = make(map[string]interface{})
+		}
+		e.Meta[codec.Key(key)] = value
+	}
+}
+
+// WithCode sets the machine readable code of an error response
+func WithCode(code string) Option {
+	return func(e *Envelope) {
+		e.Code = code
+	}
+}
+
+// WithDebug adds internal details, only ever used in debug mode
+func WithDebug(debug string) Option {
+	return func(e *Envelope) {
+		e.Debug = debug
+	}
+}
+
+// WithLink sets one entry of "links"
+func WithLink(rel, href string) Option {
+	return func(e *Envelope) {
+		if href == "" {
+			return
+		}
+		if e.Links == nil {
+			e.Links = make(map[string]string)
+		}
+		e.Links[rel] = href
+	}
+}
+
+// WithPage adds the metadata and links of a paginated listing
+func WithPage(meta pagination.Meta, links pagination.Links) Option {
+	return func(e *Envelope) {
+		WithMeta("limit", meta.Limit)(e)
+		WithMeta("has_more", meta.HasMore)(e)
+		if meta.NextCursor != "" {
+			WithMeta("next_cursor", meta.NextCursor)(e)
+		}
+		WithLink("self", links.Self)(e)
+		WithLink("first", links.First)(e)
+		WithLink("next", links.Next)(e)
+	}
+}
+
+// WithTiming adds how long the request took so far, in milliseconds
+func WithTiming(start time.Time) Option {
+	return WithMeta("took_ms", float64(time.Since(start).Microseconds())/1000)
+}
+
+// New builds an envelope, success follows the status and data is always a list
+func New(status int, message string, data interface{}, options ...Option) Envelope {
+	e := Envelope{
+		Success: status < fiber.StatusBadRequest,
+		Status:  status,
+		Message: message,
+		Data:    asList(data),
+	}
+	for _, option := range options {
+		option(&e)
+	}
+	return e
+}
+
+// Send writes the envelope with its status and content type in one go, in the format the Accept header asks for.
+// Success responses nobody can accept are a 406, error responses fall back to JSON instead,
+// or go out as application/problem+json when the config or the client prefer it.
+func Send(c *fiber.Ctx, status int, message string, data interface{}, options ...Option) error {
+	c.Vary(fiber.HeaderAccept)
+	if status >= fiber.StatusBadRequest && wantsProblem(c) {
+		return sendProblem(c, New(status, message, data, options...), data)
+	}
+
+	name, ok := Negotiate(c, status < fiber.StatusBadRequest)
+	if !ok {
+		if status < fiber.StatusBadRequest {
+			return notAcceptable()
+		}
+		name = JSON
+	}
+
+	f := formats[name]
+	output, err := f.encode(New(status, message, data, options...))
+	if err != nil {
+		return fmt.Errorf("Error converting the response to %s: %w", name, err)
+	}
+
+	c.Status(status)
+	c.Set(fiber.HeaderContentType, f.mediaTypes[0])
+	return c.Send(output)
+}
+
+// asList keeps "data" a list whatever the handler passes in
+func asList(data interface{}) interface{} {
+	if data == nil {
+		return make([]int, 0)
+	}
+	kind := reflect.TypeOf(data).Kind()
+	if kind == reflect.Slice || kind == reflect.Array {
+		if reflect.ValueOf(data).IsNil() && kind == reflect.Slice {
+			return make([]int, 0)
+		}
+		return data
+	}
+	return []interface{}{data}
+}
@@ -0,0 +1,143 @@
+package sparse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// Relation is a related resource that include= can embed in its parent
+type Relation struct {
+	// Load reads the related resources of every parent at once and returns them by parent key,
+	// the keys are the values of the parents' key field as they are, include is what to embed in them in turn
+	Load func(ctx context.Context, keys []interface{}, include Include) (map[interface{}]interface{}, error)
+	// Relations of the related resource itself, for include=a.b
+	Relations map[string]Relation
+}
+
+// Schema whitelists what fields= and include= accept for a resource
+type Schema struct {
+	// Fields by the name clients use
+	Fields []string
+	// Relations by the name clients use
+	Relations map[string]Relation
+}
+
+// Include is the tree of relations to embed, include=a.b,c is {a: {b: {}}, c: {}}
+type Include map[string]Include
+
+// Selection is a parsed fields= and include=
+type Selection struct {
+	schema Schema
+	// Fields asked for, nil when fields= is missing and every field goes out
+	Fields  []string
+	Include Include
+}
+
+// Parse reads fields=user_id,name and include=a,a.b out of the query string,
+// unknown fields and relations are a 400, and so is an include nested deeper than maxDepth
+func Parse(c *fiber.Ctx, schema Schema, maxDepth int) (Selection, error) {
+	s := Selection{schema: schema, Include: Include{}}
+
+	if raw := strings.TrimSpace(c.Query("fields")); raw != "" {
+		known := make(map[string]bool, len(schema.Fields))
+		for _, f := range schema.Fields {
+			known[f] = true
+		}
+		s.Fields = make([]string, 0, len(known))
+		seen := make(map[string]bool, len(known))
+		for _, f := range strings.Split(raw, ",") {
+			f = strings.TrimSpace(f)
+			if !known[f] {
+				return s, apperrors.BadRequest("unknown_field", fmt.Sprintf("Unknown field %q, pick from %s.", f, strings.Join(schema.Fields, ", ")))
+			}
+			if !seen[f] {
+				seen[f] = true
+				s.Fields = append(s.Fields, f)
+			}
+		}
+	}
+
+	if raw := strings.TrimSpace(c.Query("include")); raw != "" {
+		for _, path := range strings.Split(raw, ",") {
+			path = strings.TrimSpace(path)
+			names := strings.Split(path, ".")
+			if len(names) > maxDepth {
+				return s, apperrors.BadRequest("include_too_deep", fmt.Sprintf("include %q is %d levels deep, at most %d are allowed.", path, len(names), maxDepth))
+			}
+
+			// every step has to be a relation of the one before it
+			relations, node := schema.Relations, s.Include
+			for _, name := range names {
+				relation, ok := relations[name]
+				if !ok && len(relations) == 0 {
+					return s, apperrors.BadRequest("unknown_include", fmt.Sprintf("Unknown include %q, there is nothing to include here.", path))
+				}
+				if !ok {
+					return s, apperrors.BadRequest("unknown_include", fmt.Sprintf("Unknown include %q, pick from %s.", path, relationNames(relations)))
+				}
+				if node[name] == nil {
+					node[name] = Include{}
+				}
+				relations, node = relation.Relations, node[name]
+			}
+		}
+	}
+	return s, nil
+}
+
+// All is true when the full resource goes out as it is, nothing left out and nothing embedded
+func (s Selection) All() bool {
+	return s.Fields == nil && len(s.Include) == 0
+}
+
+// Wants is true when the field goes out
+func (s Selection) Wants(field string) bool {
+	if s.Fields == nil {
+		return true
+	}
+	for _, f := range s.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Embed loads the included relations of rows and adds them to every row under the relation name,
+// keys are the keys of the rows in the same order, they don't have to be among the fields that go out
+func (s Selection) Embed(ctx context.Context, rows []map[string]interface{}, keys []interface{}) error {
+	return Embed(ctx, s.schema.Relations, s.Include, rows, keys)
+}
+
+// Embed is Selection.Embed for loaders that embed the nested includes of the resources they load,
+// rows a relation has nothing for get a nil, keys follow the naming of the JSON codec
+func Embed(ctx context.Context, relations map[string]Relation, include Include, rows []map[string]interface{}, keys []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	for name, nested := range include {
+		related, err := relations[name].Load(ctx, keys, nested)
+		if err != nil {
+			return fmt.Errorf("error loading the included %s: %w", name, err)
+		}
+		for i, row := range rows {
+			row[codec.Key(name)] = related[keys[i]]
+		}
+	}
+	return nil
+}
+
+func relationNames(relations map[string]Relation) string {
+	names := make([]string, 0, len(relations))
+	for name := range relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
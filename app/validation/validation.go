@@ -0,0 +1,267 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// FieldError is one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors is every invalid field of a request
+type Errors []FieldError
+
+// Error implements error
+func (e Errors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, f := range e {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Bind parses the JSON, XML or form body into out and validates it,
+// a body that does not parse is a 400 and one that does not validate a 422 listing every invalid field
+func Bind(c *fiber.Ctx, out interface{}) error {
+	if err := c.BodyParser(out); err != nil {
+		return apperrors.InvalidBody(err)
+	}
+	return Check(out)
+}
+
+// Check validates v and wraps the result into a 422 AppError, nil when v is valid
+func Check(v interface{}) error {
+	if errs := Validate(v); len(errs) > 0 {
+		return Failed(errs)
+	}
+	return nil
+}
+
+// Failed is the 422 AppError of a set of invalid fields
+func Failed(errs Errors) *apperrors.AppError {
+	return apperrors.Wrap(errs, fiber.StatusUnprocessableEntity, "validation_failed", "The request has invalid fields.").WithDetails(errs)
+}
+
+// Validate checks v, a struct or a pointer to one, against the rules in its tags:
+//
+//	validate:"required,min=1,max=100,oneof=admin user,email,url"
+//	pattern:"^[a-z]+$"
+//
+// min and max are rune counts for strings, lengths for slices and values for numbers.
+// email wants a bare address, without a display name or angle brackets, url an absolute http or https URL,
+// empty strings are left to required.
+func Validate(v interface{}) Errors {
+	errs := make(Errors, 0)
+	validateValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, prefix string, errs *Errors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				// unexported
+				continue
+			}
+			name := fieldName(sf)
+			if name == "-" {
+				continue
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fv := v.Field(i)
+			if ok := validateField(sf, fv, name, errs); ok {
+				validateValue(fv, name, errs)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), prefix+"["+strconv.Itoa(i)+"]", errs)
+		}
+	}
+}
+
+// validateField applies the rules of one field, it returns false when the field is already invalid
+func validateField(sf reflect.StructField, fv reflect.Value, name string, errs *Errors) bool {
+	rules := sf.Tag.Get("validate")
+	pattern := sf.Tag.Get("pattern")
+	if rules == "" && pattern == "" {
+		return true
+	}
+
+	// optional fields given as a nil pointer have nothing to check
+	value := fv
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			if hasRule(rules, "required") {
+				*errs = append(*errs, FieldError{Field: name, Code: "required", Message: "is required"})
+				return false
+			}
+			return true
+		}
+		value = value.Elem()
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		key, arg := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			key, arg = rule[:i], rule[i+1:]
+		}
+
+		if fe, ok := applyRule(key, arg, value); !ok {
+			fe.Field = name
+			*errs = append(*errs, fe)
+			return false
+		}
+	}
+
+	if pattern != "" && value.Kind() == reflect.String {
+		if !compile(pattern).MatchString(value.String()) {
+			*errs = append(*errs, FieldError{Field: name, Code: "pattern", Message: "has an invalid format"})
+			return false
+		}
+	}
+	return true
+}
+
+func applyRule(key, arg string, value reflect.Value) (FieldError, bool) {
+	switch key {
+	case "required":
+		if isBlank(value) {
+			return FieldError{Code: "required", Message: "is required"}, false
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid %s rule %q", key, arg))
+		}
+		size, unit := measure(value)
+		if key == "min" && size < limit {
+			return FieldError{Code: "min", Message: fmt.Sprintf("must be at least %s%s", arg, unit)}, false
+		}
+		if key == "max" && size > limit {
+			return FieldError{Code: "max", Message: fmt.Sprintf("must be at most %s%s", arg, unit)}, false
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		current := fmt.Sprint(value.Interface())
+		for _, option := range options {
+			if option == current {
+				return FieldError{}, true
+			}
+		}
+		return FieldError{Code: "oneof", Message: "must be one of " + strings.Join(options, ", ")}, false
+	case "email":
+		if value.Kind() == reflect.String && value.String() != "" && !isEmail(value.String()) {
+			return FieldError{Code: "email", Message: "must be a valid email address"}, false
+		}
+	case "url":
+		if value.Kind() == reflect.String && value.String() != "" && !isURL(value.String()) {
+			return FieldError{Code: "url", Message: "must be an absolute http or https URL"}, false
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", key))
+	}
+	return FieldError{}, true
+}
+
+// measure returns what min and max compare against, and the unit to show in messages
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	return 0, ""
+}
+
+// isBlank is true for zero values and strings made of whitespace only
+func isBlank(value reflect.Value) bool {
+	if value.Kind() == reflect.String {
+		return strings.TrimSpace(value.String()) == ""
+	}
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Map {
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// isEmail is true for a bare address like jane@example.com, "Jane <jane@example.com>" parses but isn't one
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Name == "" && address.Address == value && strings.Contains(value[strings.LastIndexByte(value, '@'):], ".")
+}
+
+func isURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func hasRule(rules, rule string) bool {
+	for _, r := range strings.Split(rules, ",") {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName is the name clients know the field by, the json one when there is a tag
+func fieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	// same naming strategy as the responses
+	return codec.Name(sf.Name)
+}
+
+// patterns are compiled once, struct tags never change at runtime
+var patterns sync.Map
+
+func compile(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	patterns.Store(pattern, re)
+	return re
+}
@@ -0,0 +1,251 @@
+package versioning
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/apperrors"
+)
+
+// Config of the API versions
+type Config struct {
+	// Versions served, oldest first
+	Versions []string
+	// Version of the requests that don't pick one, the latest when empty
+	Default string
+	// Request header a version can be picked with, API-Version: v2 or API-Version: 2
+	Header string
+	// Vendor media type a version can be picked with, Accept: application/vnd.crayplate.v2+json,
+	// Accept: application/json; version=2 works too
+	MediaType string
+	// Deprecated versions and when they go away
+	Deprecated map[string]Deprecation
+}
+
+// ConfigDefault is used for every field left empty
+var ConfigDefault = Config{
+	Versions:  []string{"v1", "v2"},
+	Default:   "v1",
+	Header:    "API-Version",
+	MediaType: "application/vnd.crayplate",
+}
+
+// Deprecation of a version or of a single route, dates are YYYY-MM-DD or RFC 3339
+type Deprecation struct {
+	// Since when it is deprecated, the Deprecation header
+	Date string
+	// When it stops working, the Sunset header
+	Sunset string
+	// Where to read about what replaces it, a Link header with rel="deprecation"
+	Link string
+}
+
+// Versions serves the same routes under several versions
+type Versions struct {
+	config      Config
+	known       map[string]bool
+	deprecation map[string]fiber.Handler
+}
+
+// New checks the configuration, every version has to be unique
+// and every deprecation has to have valid dates
+func New(config Config) (*Versions, error) {
+	if len(config.Versions) == 0 {
+		config.Versions = ConfigDefault.Versions
+	}
+	if config.Default == "" {
+		config.Default = config.Versions[len(config.Versions)-1]
+	}
+	if config.Header == "" {
+		config.Header = ConfigDefault.Header
+	}
+	if config.MediaType == "" {
+		config.MediaType = ConfigDefault.MediaType
+	}
+
+	v := &Versions{config: config, known: make(map[string]bool), deprecation: make(map[string]fiber.Handler)}
+	for _, version := range config.Versions {
+		if !isVersion(version) || v.known[version] {
+			return nil, fmt.Errorf("invalid or duplicate API version %q", version)
+		}
+		v.known[version] = true
+	}
+	if !v.known[config.Default] {
+		return nil, fmt.Errorf("default API version %q is not served", config.Default)
+	}
+	for version, d := range config.Deprecated {
+		if !v.known[version] {
+			return nil, fmt.Errorf("deprecated API version %q is not served", version)
+		}
+		if err := d.check(); err != nil {
+			return nil, fmt.Errorf("invalid deprecation of API version %s: %w", version, err)
+		}
+		v.deprecation[version] = Deprecate(version, d)
+	}
+	return v, nil
+}
+
+// Each registers the routes of every version, register gets the group of the version,
+// the group of a deprecated version already sends the deprecation headers
+func (v *Versions) Each(api fiber.Router, register func(version string, router fiber.Router)) {
+	for _, version := range v.config.Versions {
+		var handlers []fiber.Handler
+		if handler, ok := v.deprecation[version]; ok {
+			handlers = append(handlers, handler)
+		}
+		register(version, api.Group("/"+version, handlers...))
+	}
+}
+
+// Deprecated tells whether a whole version is deprecated
+func (v *Versions) Deprecated(version string) bool {
+	_, ok := v.deprecation[version]
+	return ok
+}
+
+// Negotiate is mounted on the API group (prefix being its path) before the versions,
+// a path without a version is given the one picked by the header, by Accept or the default
+func (v *Versions) Negotiate(prefix string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rest := strings.TrimPrefix(c.Path(), prefix)
+		first := strings.SplitN(strings.TrimPrefix(rest, "/"), "/", 2)[0]
+
+		if isVersion(first) {
+			if !v.known[first] {
+				return v.unsupported(first)
+			}
+			c.Set(v.config.Header, first)
+			return c.Next()
+		}
+
+		c.Vary(v.config.Header, fiber.HeaderAccept)
+		version, err := v.requested(c)
+		if err != nil {
+			return err
+		}
+		c.Set(v.config.Header, version)
+		c.Path(prefix + "/" + version + rest)
+		return c.Next()
+	}
+}
+
+// requested is the version the header or Accept asks for, the default when neither does
+func (v *Versions) requested(c *fiber.Ctx) (string, error) {
+	if header := c.Get(v.config.Header); header != "" {
+		return v.lookup(header)
+	}
+
+	accept := c.Get(fiber.HeaderAccept)
+	vendor := v.config.MediaType + "."
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		// application/vnd.crayplate.v2+json, the rest of the app only knows application/json
+		if strings.HasPrefix(mediaType, vendor) {
+			version := strings.TrimPrefix(mediaType, vendor)
+			if i := strings.IndexByte(version, '+'); i >= 0 {
+				version = version[:i]
+			}
+			c.Request().Header.Set(fiber.HeaderAccept, strings.Replace(accept, strings.TrimSpace(params[0]), fiber.MIMEApplicationJSON, 1))
+			return v.lookup(version)
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "version=") {
+				return v.lookup(strings.Trim(param[len("version="):], `"`))
+			}
+		}
+	}
+	return v.config.Default, nil
+}
+
+// lookup accepts v2 as well as 2
+func (v *Versions) lookup(version string) (string, error) {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !v.known[version] {
+		return "", v.unsupported(version)
+	}
+	return version, nil
+}
+
+func (v *Versions) unsupported(version string) error {
+	return apperrors.New(fiber.StatusNotFound, "unsupported_api_version",
+		fmt.Sprintf("API version %s is not served, the versions are %s.", version, strings.Join(v.config.Versions, ", ")))
+}
+
+// Deprecate marks the routes it is mounted on as deprecated, the responses carry
+// the Deprecation and Sunset headers and every use is logged so we know who still calls them.
+// It panics on invalid dates like regexp.MustCompile, versions deprecated in the config are checked by New.
+func Deprecate(version string, d Deprecation) fiber.Handler {
+	if err := d.check(); err != nil {
+		panic(fmt.Sprintf("invalid deprecation of API version %s: %v", version, err))
+	}
+
+	// the date may be unknown, the header still tells the route is deprecated
+	deprecation := "true"
+	if d.Date != "" {
+		date, _ := parseDate(d.Date)
+		deprecation = date.Format(http.TimeFormat)
+	}
+	var sunset string
+	if d.Sunset != "" {
+		date, _ := parseDate(d.Sunset)
+		sunset = date.Format(http.TimeFormat)
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", deprecation)
+		if sunset != "" {
+			c.Set("Sunset", sunset)
+		}
+		if d.Link != "" {
+			c.Append(fiber.HeaderLink, `<`+d.Link+`>; rel="deprecation"`)
+		}
+		log.Printf("Deprecated API %s used: %s %s by %s (%s)", version, c.Method(), c.OriginalURL(), c.IP(), c.Get(fiber.HeaderUserAgent))
+		return c.Next()
+	}
+}
+
+// check validates the dates
+func (d Deprecation) check() error {
+	if d.Date != "" {
+		if _, err := parseDate(d.Date); err != nil {
+			return fmt.Errorf("date: %w", err)
+		}
+	}
+	if d.Sunset != "" {
+		if _, err := parseDate(d.Sunset); err != nil {
+			return fmt.Errorf("sunset: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseDate reads a YYYY-MM-DD day or an RFC 3339 time
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// isVersion tells v1, v2... apart from the resources
+func isVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateAddress is the error of a receiver that resolves into the private network
+var ErrPrivateAddress = errors.New("webhooks: the receiver resolves to a private, loopback or link-local address")
+
+// blockedNetworks are the addresses deliveries never go to, anyone allowed to subscribe could otherwise make
+// the server post into the network it runs in: the database, the cloud metadata service, the admin ports
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, the cloud metadata services live here
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, broadcast included
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4 translation, it reaches the IPv4 network behind it
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// blocked is true for the addresses deliveries never go to, IPv4 addresses mapped into IPv6 included
+func blocked(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL makes sure a receiver URL is http(s) and that every address its host resolves to is public,
+// unless AllowPrivateNetworks is on. The host can resolve elsewhere later, the dialer checks again on every delivery
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("webhooks: %q is not an http or https URL", raw)
+	}
+	if current.AllowPrivateNetworks {
+		return nil
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhooks: resolving %s: %w", u.Hostname(), err)
+	}
+	for _, address := range addresses {
+		if blocked(address.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to blocked addresses, it runs on the address actually dialed
+// so a name that resolved to a public address when it was subscribed can't be pointed inside afterwards
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if current.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blocked(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
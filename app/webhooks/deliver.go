@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// UserAgent of the deliveries
+const UserAgent = "fiber-crayplate-webhooks"
+
+// delivery is a claimed delivery on its way out
+type delivery struct {
+	id       int64
+	eventID  string
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+	// leasedUntil is the next_attempt_at claim set, a redelivery in the meantime changes it
+	leasedUntil time.Time
+}
+
+// attempt is the outcome of sending a delivery once
+type attempt struct {
+	statusCode int
+	err        string
+	duration   time.Duration
+}
+
+func (a attempt) ok() bool {
+	return a.err == "" && a.statusCode >= 200 && a.statusCode < 300
+}
+
+var client = &http.Client{
+	// no proxy, the address the dialer checks has to be the receiver's own
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialControl}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	// a receiver answering with a redirect is a receiver that moved, the subscription has to be fixed
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// DeliverDue sends every delivery that is due, BatchSize at a time, and returns how many were sent.
+// Deliveries are claimed with SKIP LOCKED so several instances can run it side by side
+func DeliverDue(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	sent := 0
+	for {
+		batch, err := claim(ctx, db)
+		if err != nil {
+			return sent, err
+		}
+		if len(batch) == 0 {
+			return sent, nil
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(batch))
+		for _, d := range batch {
+			wg.Add(1)
+			go func(d delivery) {
+				defer wg.Done()
+				if err := record(ctx, db, d, send(ctx, d)); err != nil {
+					errs <- err
+				}
+			}(d)
+		}
+		wg.Wait()
+		close(errs)
+		if err := <-errs; err != nil {
+			return sent, err
+		}
+		sent += len(batch)
+
+		if len(batch) < current.BatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// claim takes the deliveries that are due and pushes their next attempt past the time sending them can take,
+// so a crash halfway leaves them to be picked up again instead of stuck
+func claim(ctx context.Context, db *pgxpool.Pool) ([]delivery, error) {
+	lease := 2 * current.Timeout
+	rows, err := db.Query(ctx, `UPDATE webhook_deliveries d SET next_attempt_at = now() + $1::interval
+		FROM webhook_subscriptions s
+		WHERE s.subscription_id = d.subscription_id AND d.delivery_id IN (
+			SELECT delivery_id FROM webhook_deliveries WHERE status = $2 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.delivery_id, d.event_id, d.event, d.payload::text, d.attempts, s.url, s.secret, d.next_attempt_at`,
+		fmt.Sprintf("%d milliseconds", lease.Milliseconds()), Pending, current.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	batch := make([]delivery, 0, current.BatchSize)
+	for rows.Next() {
+		d := delivery{}
+		var payload string
+		if err := rows.Scan(&d.id, &d.eventID, &d.event, &payload, &d.attempts, &d.url, &d.secret, &d.leasedUntil); err != nil {
+			return nil, fmt.Errorf("error scanning webhook deliveries: %w", err)
+		}
+		d.payload = []byte(payload)
+		batch = append(batch, d)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error reading webhook deliveries: %w", rows.Err())
+	}
+	return batch, nil
+}
+
+// send posts a delivery to its subscription once, signed with the time it leaves
+func send(ctx context.Context, d delivery) attempt {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, current.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return attempt{err: err.Error(), duration: time.Since(start)}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set(HeaderID, d.eventID)
+	req.Header.Set(HeaderEvent, d.event)
+	req.Header.Set(HeaderSignature, Sign(d.secret, start, d.payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return attempt{err: err.Error(), duration: time.Since(start)}
+	}
+	// only the status counts, what a receiver answers is never kept or shown to whoever subscribed it
+	res.Body.Close()
+	return attempt{statusCode: res.StatusCode, duration: time.Since(start)}
+}
+
+// record logs an attempt and moves the delivery on, to delivered, to its next attempt or to dead.
+// A delivery redelivered while it was being sent is left as the redelivery made it, only the attempt is logged
+func record(ctx context.Context, db *pgxpool.Pool, d delivery, a attempt) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error recording webhook delivery %d: %w", d.id, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "INSERT INTO webhook_attempts(delivery_id, status_code, error, duration_ms) VALUES($1, $2, $3, $4)",
+		d.id, nullInt(a.statusCode), nullString(a.err), a.duration.Milliseconds()); err != nil {
+		return fmt.Errorf("error logging the attempt of webhook delivery %d: %w", d.id, err)
+	}
+
+	attempts := d.attempts + 1
+	status, next := Pending, time.Now().Add(Backoff(attempts))
+	switch {
+	case a.ok():
+		status = Delivered
+	case attempts >= current.MaxAttempts:
+		status = Dead
+	}
+	// the delivery only moves on if it is still the one claimed, a redelivery resets the attempts and the lease
+	if _, err := tx.Exec(ctx, `UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt_at = $3,
+		last_status_code = $4, last_error = $5, delivered_at = CASE WHEN $1 = 'delivered' THEN now() END
+		WHERE delivery_id = $6 AND attempts = $7 AND next_attempt_at = $8`,
+		status, attempts, next, nullInt(a.statusCode), nullString(a.err), d.id, d.attempts, d.leasedUntil); err != nil {
+		return fmt.Errorf("error updating webhook delivery %d: %w", d.id, err)
+	}
+	return tx.Commit(ctx)
+}
+
+// Backoff is the wait after the given number of failed attempts, BackoffBase doubled for every attempt after the first
+func Backoff(attempts int) time.Duration {
+	wait := current.BackoffBase
+	for i := 1; i < attempts && wait < current.BackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > current.BackoffMax {
+		wait = current.BackoffMax
+	}
+	return wait
+}
+
+func nullInt(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
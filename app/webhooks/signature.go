@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	// HeaderSignature carries the timestamp and the signature, t=1700000000,v1=5257a869...
+	HeaderSignature = "Webhook-Signature"
+	// HeaderEvent is the type of the event, user.created and so on
+	HeaderEvent = "Webhook-Event"
+	// HeaderID is the id of the event, the same for every delivery and retry of it
+	HeaderID = "Webhook-Id"
+)
+
+// Errors of Verify
+var (
+	ErrMissingSignature = errors.New("webhooks: missing or malformed signature")
+	ErrBadSignature     = errors.New("webhooks: signature does not match")
+	ErrExpired          = errors.New("webhooks: timestamp outside the tolerance")
+)
+
+// Sign returns the Webhook-Signature of body sent at t, an HMAC-SHA256 of "<unix t>.<body>" keyed with the secret.
+// The timestamp is part of what is signed so an old delivery can't be replayed with a new one
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify checks a Webhook-Signature against body, for receivers and the local test receiver,
+// deliveries signed more than tolerance away from now are refused
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	signatures := make([]string, 0, 1)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrMissingSignature
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrExpired
+	}
+	expected := signature(secret, timestamp, body)
+	for _, s := range signatures {
+		// constant time, the comparison mustn't tell how much of a guess was right
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,160 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+)
+
+// Events subscriptions can ask for
+const (
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	UserRestored = "user.restored"
+)
+
+// Events are every event there is, in the order they are documented
+var Events = []string{UserCreated, UserUpdated, UserDeleted, UserRestored}
+
+// Status of a delivery
+const (
+	// Pending deliveries are waiting for their next attempt
+	Pending = "pending"
+	// Delivered deliveries got a 2xx
+	Delivered = "delivered"
+	// Dead deliveries failed MaxAttempts times and are not tried again unless redelivered
+	Dead = "dead"
+)
+
+// Config of the webhook deliveries
+type Config struct {
+	// How often the worker looks for deliveries that are due
+	PollInterval time.Duration
+	// Most deliveries sent at the same time
+	BatchSize int
+	// How long a receiver has to answer
+	Timeout time.Duration
+	// Attempts before a delivery is dead
+	MaxAttempts int
+	// Wait before the first retry, doubled for every retry after it
+	BackoffBase time.Duration
+	// Longest wait between two attempts
+	BackoffMax time.Duration
+	// Let subscriptions point at private, loopback and link-local addresses, only for trying webhooks locally
+	AllowPrivateNetworks bool
+}
+
+// ConfigDefault is used for every field left empty, the 7 retries span about an hour
+var ConfigDefault = Config{
+	PollInterval: 5 * time.Second,
+	BatchSize:    20,
+	Timeout:      10 * time.Second,
+	MaxAttempts:  8,
+	BackoffBase:  30 * time.Second,
+	BackoffMax:   time.Hour,
+}
+
+var (
+	enabled = false
+	current = ConfigDefault
+)
+
+// Configure turns deliveries on, until it is called events are not recorded at all
+func Configure(config Config) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = ConfigDefault.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = ConfigDefault.BatchSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = ConfigDefault.Timeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = ConfigDefault.MaxAttempts
+	}
+	if config.BackoffBase <= 0 {
+		config.BackoffBase = ConfigDefault.BackoffBase
+	}
+	if config.BackoffMax <= 0 {
+		config.BackoffMax = ConfigDefault.BackoffMax
+	}
+	current = config
+	enabled = true
+}
+
+// Enabled is true once Configure has been called
+func Enabled() bool {
+	return enabled
+}
+
+// Event is the body of a delivery
+type Event struct {
+	// ID is the same in the deliveries of one event to every subscription, receivers dedupe on it
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Execer is a pool or a transaction, events are best enqueued in the transaction of the change they tell about
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Enqueue records a delivery of the event for every subscription to it,
+// nothing is recorded while webhooks are disabled
+func Enqueue(ctx context.Context, db Execer, event string, data interface{}) error {
+	if !enabled {
+		return nil
+	}
+
+	id, err := newEventID()
+	if err != nil {
+		return err
+	}
+	payload, err := codec.Marshal(Event{ID: id, Type: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("error converting the %s event to json: %w", event, err)
+	}
+
+	_, err = db.Exec(ctx, `INSERT INTO webhook_deliveries(subscription_id, event_id, event, payload)
+		SELECT subscription_id, $1, $2, $3::jsonb FROM webhook_subscriptions WHERE $2 = ANY(events)`, id, event, string(payload))
+	if err != nil {
+		return fmt.Errorf("error enqueuing the %s event: %w", event, err)
+	}
+	return nil
+}
+
+// IsEvent is true for the events subscriptions can ask for
+func IsEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func newEventID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("error generating an event id: %w", err)
+	}
+	return "evt_" + hex.EncodeToString(raw), nil
+}
+
+// NewSecret generates the signing secret of a subscription
+func NewSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("error generating a webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(raw), nil
+}
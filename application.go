@@ -12,10 +12,19 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/helmet/v2"
 
+	"github.com/mikeychowy/fiber-crayplate/app/auth"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
 	"github.com/mikeychowy/fiber-crayplate/app/configuration"
+	"github.com/mikeychowy/fiber-crayplate/app/httpcache"
+	"github.com/mikeychowy/fiber-crayplate/app/idempotency"
+	"github.com/mikeychowy/fiber-crayplate/app/jobs"
 	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/response"
+	"github.com/mikeychowy/fiber-crayplate/app/versioning"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
 	"github.com/mikeychowy/fiber-crayplate/database"
 	"github.com/mikeychowy/fiber-crayplate/routes"
 )
@@ -28,11 +37,60 @@ func main() {
 		log.Fatalf("An error occurred while loading the configurations: %v", err)
 	}
 
+	// Configure the JSON codec once, before anything gets serialized
+	if err := codec.Configure(config.JSON); err != nil {
+		log.Fatalf("An error occurred while configuring the JSON codec: %v", err)
+	}
+
+	// Set the formats responses can be negotiated into
+	if err := response.Configure(config.Response); err != nil {
+		log.Fatalf("An error occurred while configuring the response formats: %v", err)
+	}
+
+	// Set the Cache-Control of the routes
+	httpcache.Configure(config.Cache)
+
+	// Set the password policy of the accounts, and the token keys when the protected routes need tokens,
+	// before the routes are registered so the OpenAPI document knows which ones are protected
+	auth.Configure(config.Auth)
+	if config.Enabled["auth"] {
+		if err := providers.SetAuthProvider(config.Auth); err != nil {
+			log.Fatalf("An error occurred while loading the token keys: %v", err)
+		}
+	}
+
+	// Check the API versions the routes are served under
+	versions, err := versioning.New(config.Versioning)
+	if err != nil {
+		log.Fatalf("An error occurred while configuring the API versions: %v", err)
+	}
+
+	// "openapi [file]" writes the OpenAPI document instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		if err := writeOpenAPI(versions, os.Args[2:]); err != nil {
+			log.Fatalf("An error occurred while writing the OpenAPI document: %v", err)
+		}
+		return
+	}
+
+	// "webhook-receiver [addr] [secret]" listens for webhook deliveries and prints them, to try webhooks locally
+	if len(os.Args) > 1 && os.Args[1] == "webhook-receiver" {
+		if err := receiveWebhooks(os.Args[2:]); err != nil {
+			log.Fatalf("An error occurred while receiving webhooks: %v", err)
+		}
+		return
+	}
+
 	// Create a new Fiber application
 	app := fiber.New(config.Fiber)
 
 	cb := context.Background()
 
+	// Give every request an id first, so everything after it can refer to it
+	if config.Enabled["requestid"] {
+		app.Use(requestid.New(config.RequestID))
+	}
+
 	// Use the Logger Middleware if enabled
 	if config.Enabled["logger"] {
 		app.Use(logger.New(config.Logger))
@@ -83,12 +141,55 @@ func main() {
 		if err != nil {
 			exit(&config, app, err)
 		}
+		// Bring the schema up to date
+		if config.Database.AutoMigrate {
+			if err := database.Migrate(cb); err != nil {
+				exit(&config, app, err)
+			}
+		}
+		// Permanently remove users that stayed in the trash past the retention
+		jobs.StartUserPurge(cb, config.Users)
+		// Remove the sessions that ended, refresh tokens only exist with auth enabled
+		if config.Enabled["auth"] {
+			jobs.StartSessionPurge(cb, config.Auth.PurgeInterval)
+			// Refuse the access tokens of the sessions that were revoked
+			providers.SetRevocationProvider(auth.NewRevocations(database.Instance(), config.Auth.RevocationCheckTTL))
+		}
+		// Record user events for the webhook subscriptions and send them
+		if config.Enabled["webhooks"] {
+			webhooks.Configure(config.Webhooks)
+			jobs.StartWebhookDelivery(cb, config.Webhooks.PollInterval)
+		}
 	}
 
-	// Register application API routes (using the /api/v1 group)
+	// Replay the stored response to retried POST and PATCH requests carrying an Idempotency-Key,
+	// the keys live in Postgres so every instance sees them, or in memory without a database.
+	// The routes run it after checking the token, see providers.Idempotent
+	if config.Enabled["idempotency"] {
+		var store idempotency.Store = idempotency.NewMemoryStore()
+		if config.Enabled["database"] {
+			store = idempotency.NewPostgresStore(database.Instance())
+		}
+		providers.SetIdempotencyProvider(idempotency.New(config.Idempotency, store))
+		jobs.StartIdempotencyPurge(cb, store, config.Idempotency.PurgeInterval)
+	}
+
+	// Serve the OpenAPI document and the docs page, before the version negotiation
+	// so /api/docs isn't taken for a route without its version
 	api := app.Group("/api")
-	apiv1 := api.Group("/v1")
-	routes.RegisterAPI(apiv1)
+	routes.RegisterDocs(api.Group("/docs"), "/api/docs")
+
+	// GraphQL has no versions either, it is served at /api/graphql
+	if config.Enabled["graphql"] {
+		if err := routes.RegisterGraphQL(api, config.GraphQL); err != nil {
+			log.Fatalf("An error occurred while building the GraphQL schema: %v", err)
+		}
+	}
+
+	// Register application API routes under every version (/api/v1, /api/v2...),
+	// /api/users is sent to the version the client asks for
+	api.Use(versions.Negotiate("/api"))
+	routes.RegisterAPI(api, versions)
 
 	// Set configuration provider
 	providers.SetConfiguration(&config)
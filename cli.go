@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/versioning"
+	"github.com/mikeychowy/fiber-crayplate/app/webhooks"
+	"github.com/mikeychowy/fiber-crayplate/routes"
+)
+
+// writeOpenAPI writes the OpenAPI document to the file in args, openapi.json when there is none
+func writeOpenAPI(versions *versioning.Versions, args []string) error {
+	file := "openapi.json"
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	// the routes only have to be registered to be documented, nothing listens
+	routes.RegisterAPI(fiber.New().Group("/api"), versions)
+	spec, err := routes.OpenAPI()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, spec, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("OpenAPI document written to %s\n", file)
+	return nil
+}
+
+// receiveWebhooks listens on the address in args, :9090 when there is none, and prints every delivery it gets.
+// With the secret of the subscription as second argument the signatures are checked and bad ones answered with 401,
+// ?status=500 in the subscription URL makes it answer with that status, to watch retries and dead deliveries
+func receiveWebhooks(args []string) error {
+	addr, secret := ":9090", ""
+	if len(args) > 0 {
+		addr = args[0]
+	}
+	if len(args) > 1 {
+		secret = args[1]
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("%s %s %s\n", r.Header.Get(webhooks.HeaderEvent), r.Header.Get(webhooks.HeaderID), r.URL.Path)
+		if secret != "" {
+			if err := webhooks.Verify(secret, r.Header.Get(webhooks.HeaderSignature), body, 5*time.Minute, time.Now()); err != nil {
+				fmt.Printf("  refused: %v\n", err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			fmt.Println("  signature ok")
+		}
+		fmt.Printf("  %s\n", body)
+
+		status := http.StatusNoContent
+		if s, err := strconv.Atoi(r.URL.Query().Get("status")); err == nil && s >= 100 && s <= 599 {
+			status = s
+		}
+		w.WriteHeader(status)
+	})
+
+	fmt.Printf("Receiving webhooks on %s\n", addr)
+	return http.ListenAndServe(addr, handler)
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// migrations are applied in order and recorded in schema_migrations,
+// only append new ones at the end of the slice and never edit old ones
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: `CREATE TABLE IF NOT EXISTS users (
+			user_id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL
+		)`,
+	},
+	{
+		Version: 2,
+		Name:    "add_users_deleted_at",
+		Up: `ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+			CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL`,
+	},
+	{
+		Version: 3,
+		Name:    "add_users_version",
+		Up:      `ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+	},
+	{
+		Version: 4,
+		Name:    "create_idempotency_keys",
+		Up: `CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key TEXT PRIMARY KEY,
+			fingerprint TEXT NOT NULL,
+			status INTEGER,
+			headers JSONB,
+			body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+			CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at)`,
+	},
+	{
+		Version: 5,
+		Name:    "add_users_email_and_timestamps",
+		// email stays nullable for the users created before it existed, the index only compares the ones that have it.
+		// The trigger keeps updated_at right whoever writes the row, the app never sets it
+		Up: `ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+			CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email));
+			CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+			BEGIN
+				NEW.updated_at = now();
+				RETURN NEW;
+			END;
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS users_set_updated_at ON users;
+			CREATE TRIGGER users_set_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE PROCEDURE set_updated_at()`,
+	},
+	{
+		Version: 6,
+		Name:    "create_webhooks",
+		// deliveries are written in the same transaction as the change they tell about,
+		// the worker picks the pending ones up and every try lands in webhook_attempts
+		Up: `CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			subscription_id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+			CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			delivery_id BIGSERIAL PRIMARY KEY,
+			subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions ON DELETE CASCADE,
+			event_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_status_code INTEGER,
+			last_error TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			delivered_at TIMESTAMPTZ
+		);
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, delivery_id);
+			CREATE TABLE IF NOT EXISTS webhook_attempts (
+			attempt_id BIGSERIAL PRIMARY KEY,
+			delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries ON DELETE CASCADE,
+			status_code INTEGER,
+			error TEXT,
+			response TEXT,
+			duration_ms INTEGER NOT NULL,
+			attempted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+			CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_idx ON webhook_attempts (delivery_id)`,
+	},
+	{
+		Version: 7,
+		Name:    "add_users_password_hash",
+		// users created through /users have no password and can't log in until one is set
+		Up: `ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT`,
+	},
+	{
+		Version: 8,
+		Name:    "create_sessions",
+		// a session is one login and the family of refresh tokens rotated out of it, only their hashes are stored.
+		// Rotated tokens stay around with rotated_at set, presenting one again is how a stolen token is caught
+		Up: `CREATE TABLE IF NOT EXISTS sessions (
+			session_id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+			user_agent TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ,
+			revoked_reason TEXT
+		);
+			CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id);
+			CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+			CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token_hash TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL REFERENCES sessions ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			rotated_at TIMESTAMPTZ
+		);
+			CREATE INDEX IF NOT EXISTS refresh_tokens_session_idx ON refresh_tokens (session_id)`,
+	},
+	{
+		Version: 9,
+		Name:    "drop_webhook_attempts_response",
+		// what receivers answer is not kept anymore, whoever subscribes a URL could read back what it said
+		Up: `ALTER TABLE webhook_attempts DROP COLUMN IF EXISTS response`,
+	},
+}
+
+// Migrate applies every migration that has not been recorded yet
+func Migrate(c context.Context) error {
+	if _, err := pool.Exec(c, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		if err := pool.QueryRow(c, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version=$1)", m.Version).Scan(&applied); err != nil {
+			return fmt.Errorf("checking migration %d: %w", m.Version, err)
+		}
+		if applied {
+			continue
+		}
+
+		// every migration runs in its own transaction so a failure leaves no half-applied schema
+		tx, err := pool.Begin(c)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(c, m.Up); err != nil {
+			_ = tx.Rollback(c)
+			return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(c, "INSERT INTO schema_migrations(version, name) VALUES($1, $2)", m.Version, m.Name); err != nil {
+			_ = tx.Rollback(c)
+			return fmt.Errorf("recording migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(c); err != nil {
+			return err
+		}
+		fmt.Printf("Applied migration %d_%s\n", m.Version, m.Name)
+	}
+	return nil
+}
@@ -2,23 +2,238 @@ package routes
 
 import (
 	Controller "github.com/mikeychowy/fiber-crayplate/app/controllers/api"
+	"github.com/mikeychowy/fiber-crayplate/app/httpcache"
+	"github.com/mikeychowy/fiber-crayplate/app/openapi"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+	"github.com/mikeychowy/fiber-crayplate/app/versioning"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 // const timeoutTick = 60 * time.Second
 
-// RegisterAPI Register All API Routes.
-func RegisterAPI(api fiber.Router) {
-	registerUsers(api)
+// docs collects the documentation of every API route as it is registered
+var docs = &openapi.Registry{}
+
+// documented registers routes on a group and records their documentation,
+// prefix is the path of the group inside the API, deprecated is set for every route of a deprecated version
+// and protected routes need an access token
+type documented struct {
+	router     fiber.Router
+	prefix     string
+	deprecated bool
+	protected  bool
+}
+
+// handle registers the handlers for the method and path of op, the last one being the controller,
+// put versioning.Deprecate in front of it to deprecate a single route (and set op.Deprecated)
+func (d documented) handle(op openapi.Operation, handlers ...fiber.Handler) {
+	if d.protected {
+		// retries are matched to the user who sent them, so idempotency runs once the token has been checked.
+		// The public routes are the auth ones, their bodies carry passwords and refresh tokens
+		// that have no business sitting in the idempotency store for a day
+		handlers = append([]fiber.Handler{providers.Authenticate, providers.Idempotent}, handlers...)
+		// with auth disabled Authenticate lets everything through, the document shouldn't ask for tokens then
+		op.Protected = providers.AuthProvider() != nil
+	}
+	d.router.Add(op.Method, op.Path, handlers...)
+	op.Path = d.prefix + op.Path
+	op.Deprecated = op.Deprecated || d.deprecated
+	docs.Add(op)
+}
+
+// RegisterAPI Register All API Routes, once for every version.
+func RegisterAPI(api fiber.Router, versions *versioning.Versions) {
+	docs = &openapi.Registry{}
+	versions.Each(api, func(version string, router fiber.Router) {
+		// v2 serves the users like v1 does for now, routes that change get a version check here
+		registerUsers(router, version, versions.Deprecated(version))
+		registerWebhooks(router, version, versions.Deprecated(version))
+		registerAuth(router, version, versions.Deprecated(version))
+	})
+}
+
+func registerUsers(api fiber.Router, version string, deprecated bool) {
+	users := documented{router: api.Group("/users"), prefix: "/" + version + "/users", deprecated: deprecated, protected: true}
+	tags := []string{version + " users"}
+	ifMatch := openapi.Param{Name: "If-Match", In: "header", Description: "ETag of the user, the change is refused with 412 when the user has moved on"}
+	userID := openapi.Param{Name: "id", In: "path", Type: "integer"}
+	ifNoneMatch := openapi.Param{Name: "If-None-Match", In: "header", Description: "ETag of a previous response, answered with 304 Not Modified while it still holds"}
+	force := openapi.Param{Name: "force", In: "query", Type: "boolean", Description: "Remove for good instead of moving to the trash"}
+
+	users.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/", Tags: tags,
+		Summary: "List users", Params: []openapi.Param{ifNoneMatch}, Listing: &Controller.UserListing, Fieldset: &Controller.UserFieldset, Paginated: true,
+		Response: Controller.User{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+	}, httpcache.Control("list_users"), Controller.GetAllUsers)
+
+	// bulk routes go before /:id too, PUT and DELETE /bulk would be taken for an id
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/bulk", Tags: tags,
+		Summary: "Create many users", Description: "mode is atomic (all or nothing, the default) or best_effort",
+		Body: Controller.UserBulkCreate{}, Response: Controller.UserBulkResult{}, Status: fiber.StatusCreated,
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusUnprocessableEntity, fiber.StatusMultiStatus},
+	}, Controller.BulkAddUsers)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/import", Tags: tags,
+		Summary: "Import users from a CSV or NDJSON file", Description: "CSV files need a header row with a name column",
+		Params: []openapi.Param{{Name: "dry_run", In: "query", Type: "boolean", Description: "Only validate, write nothing"}, {Name: "format", In: "query", Description: "csv or ndjson, taken from the file name when missing"}},
+		Body: struct {
+			File openapi.File `json:"file"`
+		}{},
+		BodyTypes: []string{fiber.MIMEMultipartForm}, Response: Controller.UserImportReport{}, Status: fiber.StatusCreated,
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusConflict, fiber.StatusUnsupportedMediaType, fiber.StatusUnprocessableEntity},
+	}, Controller.ImportUsers)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPut, Path: "/bulk", Tags: tags,
+		Summary: "Edit many users", Description: "mode is atomic (all or nothing, the default) or best_effort, version works like If-Match",
+		Body: Controller.UserBulkEdit{}, Response: Controller.UserBulkResult{},
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusUnprocessableEntity, fiber.StatusMultiStatus},
+	}, Controller.BulkEditUsers)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodDelete, Path: "/bulk", Tags: tags,
+		Summary: "Delete many users", Description: "mode is atomic (all or nothing, the default) or best_effort, version works like If-Match",
+		Params: []openapi.Param{force}, Body: Controller.UserBulkDelete{}, Response: Controller.UserBulkResult{}, Status: fiber.StatusAccepted,
+		Errors: []int{fiber.StatusBadRequest, fiber.StatusUnprocessableEntity, fiber.StatusMultiStatus},
+	}, Controller.BulkDeleteUsers)
+
+	// the trash and the export have to be registered before /:id or they would be matched as an id
+	users.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/trash", Tags: tags,
+		Summary: "List the users in the trash", Response: Controller.User{}, Paginated: true,
+	}, httpcache.Control("trashed_users"), Controller.GetTrashedUsers)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/export", Tags: tags,
+		Summary: "Export users as NDJSON or CSV", Listing: &Controller.UserListing,
+		Params:   []openapi.Param{{Name: "format", In: "query", Description: "ndjson or csv, taken from Accept when missing"}},
+		RawTypes: []string{"application/x-ndjson", "text/csv"}, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotAcceptable},
+	}, httpcache.Control("export_users"), Controller.ExportUsers)
+
+	users.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/:id", Tags: tags,
+		Summary: "Get a user", Params: []openapi.Param{userID, ifNoneMatch, {Name: "If-Modified-Since", In: "header", Description: "Answered with 304 Not Modified when the user has not changed since, If-None-Match wins when both are sent"}}, Fieldset: &Controller.UserFieldset,
+		Response: Controller.User{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+	}, httpcache.Control("get_user"), Controller.GetUser)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/", Tags: tags,
+		Summary: "Create a user", Body: Controller.UserInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.User{}, Status: fiber.StatusCreated, Errors: []int{fiber.StatusBadRequest, fiber.StatusConflict, fiber.StatusUnprocessableEntity},
+	}, Controller.AddUser)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/:id/restore", Tags: tags,
+		Summary: "Restore a user from the trash", Params: []openapi.Param{userID},
+		Response: Controller.User{}, Errors: []int{fiber.StatusNotFound},
+	}, Controller.RestoreUser)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPut, Path: "/:id", Tags: tags,
+		Summary: "Edit a user", Params: []openapi.Param{userID, ifMatch},
+		Body: Controller.UserInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.User{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound, fiber.StatusConflict, fiber.StatusPreconditionFailed, fiber.StatusPreconditionRequired, fiber.StatusUnprocessableEntity},
+	}, Controller.EditUser)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodPatch, Path: "/:id", Tags: tags,
+		Summary: "Patch a user", Description: "With a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)",
+		Params: []openapi.Param{userID, ifMatch}, Body: Controller.UserInput{}, BodyTypes: []string{"application/merge-patch+json"},
+		Response: Controller.User{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound, fiber.StatusConflict, fiber.StatusPreconditionFailed, fiber.StatusUnsupportedMediaType, fiber.StatusUnprocessableEntity},
+	}, Controller.PatchUser)
+	users.handle(openapi.Operation{
+		Method: fiber.MethodDelete, Path: "/:id", Tags: tags,
+		Summary: "Move a user to the trash", Params: []openapi.Param{userID, force},
+		Status: fiber.StatusAccepted, Errors: []int{fiber.StatusNotFound},
+	}, Controller.DeleteUser)
+}
+
+func registerWebhooks(api fiber.Router, version string, deprecated bool) {
+	webhooks := documented{router: api.Group("/webhooks"), prefix: "/" + version + "/webhooks", deprecated: deprecated, protected: true}
+	tags := []string{version + " webhooks"}
+	webhookID := openapi.Param{Name: "id", In: "path", Type: "integer"}
+	deliveryID := openapi.Param{Name: "delivery", In: "path", Type: "integer"}
+
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/", Tags: tags,
+		Summary: "List webhook subscriptions", Response: Controller.Webhook{},
+	}, Controller.GetAllWebhooks)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/", Tags: tags,
+		Summary: "Subscribe to user events", Description: "The response carries the secret deliveries are signed with, it is not shown again",
+		Body: Controller.WebhookInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.Webhook{}, Status: fiber.StatusCreated, Errors: []int{fiber.StatusBadRequest, fiber.StatusUnprocessableEntity},
+	}, Controller.AddWebhook)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/:id", Tags: tags,
+		Summary: "Get a webhook subscription", Params: []openapi.Param{webhookID},
+		Response: Controller.Webhook{}, Errors: []int{fiber.StatusNotFound},
+	}, Controller.GetWebhook)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodDelete, Path: "/:id", Tags: tags,
+		Summary: "Remove a webhook subscription and its deliveries", Params: []openapi.Param{webhookID},
+		Status: fiber.StatusAccepted, Errors: []int{fiber.StatusNotFound},
+	}, Controller.DeleteWebhook)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/:id/deliveries", Tags: tags,
+		Summary: "List the deliveries of a webhook subscription", Description: "Newest first",
+		Params:   []openapi.Param{webhookID, {Name: "status", In: "query", Description: "pending, delivered or dead"}},
+		Response: Controller.WebhookDelivery{}, Paginated: true, Errors: []int{fiber.StatusBadRequest, fiber.StatusNotFound},
+	}, Controller.GetWebhookDeliveries)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/:id/deliveries/:delivery", Tags: tags,
+		Summary: "Get a delivery with its payload and attempts", Params: []openapi.Param{webhookID, deliveryID},
+		Response: Controller.WebhookDelivery{}, Errors: []int{fiber.StatusNotFound},
+	}, Controller.GetWebhookDelivery)
+	webhooks.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/:id/deliveries/:delivery/redeliver", Tags: tags,
+		Summary: "Send a delivery again", Description: "Dead deliveries get their full number of attempts back",
+		Params: []openapi.Param{webhookID, deliveryID}, Response: Controller.WebhookDelivery{}, Status: fiber.StatusAccepted, Errors: []int{fiber.StatusNotFound},
+	}, Controller.RedeliverWebhook)
 }
 
-func registerUsers(api fiber.Router) {
-	users := api.Group("/users")
+// the auth routes are where tokens come from, only /me needs one
+func registerAuth(api fiber.Router, version string, deprecated bool) {
+	auth := documented{router: api.Group("/auth"), prefix: "/" + version + "/auth", deprecated: deprecated}
+	tags := []string{version + " auth"}
+
+	auth.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/register", Tags: tags,
+		Summary: "Register a user with a password", Description: "The password has to follow the policy in auth.yaml",
+		Body: Controller.RegisterInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.User{}, Status: fiber.StatusCreated, Errors: []int{fiber.StatusBadRequest, fiber.StatusConflict, fiber.StatusUnprocessableEntity},
+	}, Controller.Register)
+	auth.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/login", Tags: tags,
+		Summary: "Log in with an email and a password", Description: "Answers with an access token for the protected routes, an unknown email and a wrong password get the same 401",
+		Body: Controller.LoginInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.LoginResult{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusUnauthorized, fiber.StatusUnprocessableEntity},
+	}, Controller.Login)
+
+	auth.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/refresh", Tags: tags,
+		Summary: "Exchange a refresh token for new tokens", Description: "A refresh token works once, using it again ends its session",
+		Body: Controller.RefreshInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Response: Controller.LoginResult{}, Errors: []int{fiber.StatusBadRequest, fiber.StatusUnauthorized, fiber.StatusUnprocessableEntity},
+	}, Controller.Refresh)
+	auth.handle(openapi.Operation{
+		Method: fiber.MethodPost, Path: "/logout", Tags: tags,
+		Summary: "End the session of a refresh token", Description: "Access tokens already issued stay valid until they expire",
+		Body: Controller.RefreshInput{}, BodyTypes: []string{fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMEApplicationForm},
+		Status: fiber.StatusAccepted, Errors: []int{fiber.StatusBadRequest, fiber.StatusUnauthorized, fiber.StatusUnprocessableEntity},
+	}, Controller.Logout)
 
-	users.Get("/", Controller.GetAllUsers)
-	users.Get("/:id", Controller.GetUser)
-	users.Post("/", Controller.AddUser)
-	users.Put("/:id", Controller.EditUser)
-	users.Delete("/:id", Controller.DeleteUser)
+	me := documented{router: auth.router, prefix: auth.prefix, deprecated: deprecated, protected: true}
+	me.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/me", Tags: tags,
+		Summary: "Get the user of the access token", Response: Controller.User{}, Errors: []int{fiber.StatusNotFound},
+	}, Controller.Me)
+	me.handle(openapi.Operation{
+		Method: fiber.MethodGet, Path: "/sessions", Tags: tags,
+		Summary: "List the sessions of the user of the access token", Response: Controller.Session{},
+	}, Controller.GetSessions)
+	me.handle(openapi.Operation{
+		Method: fiber.MethodDelete, Path: "/sessions", Tags: tags,
+		Summary: "Log out every session", Status: fiber.StatusAccepted,
+	}, Controller.DeleteSessions)
+	me.handle(openapi.Operation{
+		Method: fiber.MethodDelete, Path: "/sessions/:id", Tags: tags,
+		Summary: "Log out one session", Params: []openapi.Param{{Name: "id", In: "path"}},
+		Status: fiber.StatusAccepted, Errors: []int{fiber.StatusNotFound},
+	}, Controller.DeleteSession)
 }
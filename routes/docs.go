@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mikeychowy/fiber-crayplate/app/codec"
+	"github.com/mikeychowy/fiber-crayplate/app/openapi"
+)
+
+// apiInfo describes the API in the OpenAPI document
+var apiInfo = openapi.Info{
+	Title:   "fiber-crayplate API",
+	Version: "1.0.0",
+	Server:  "/api",
+}
+
+// OpenAPI builds the OpenAPI document of the routes registered by RegisterAPI
+func OpenAPI() ([]byte, error) {
+	return codec.JSON().MarshalIndent(docs.Document(apiInfo), "", "  ")
+}
+
+// RegisterDocs serves the OpenAPI document at /openapi.json and the docs page at / of the group
+func RegisterDocs(router fiber.Router, prefix string) {
+	// the routes don't change once the app runs, build the document once, on the first request
+	// since the docs are registered before the API and its version negotiation
+	var (
+		once sync.Once
+		spec []byte
+		err  error
+	)
+
+	router.Get("/openapi.json", func(c *fiber.Ctx) error {
+		once.Do(func() { spec, err = OpenAPI() })
+		if err != nil {
+			return err
+		}
+		c.Type("json")
+		return c.Send(spec)
+	})
+	router.Get("/", openapi.UI(apiInfo.Title, prefix+"/openapi.json"))
+}
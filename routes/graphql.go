@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	Controller "github.com/mikeychowy/fiber-crayplate/app/controllers/api"
+	"github.com/mikeychowy/fiber-crayplate/app/gql"
+	"github.com/mikeychowy/fiber-crayplate/app/providers"
+)
+
+// RegisterGraphQL serves the GraphQL schema at /graphql of the router, queries with GET or POST, mutations with POST only
+func RegisterGraphQL(router fiber.Router, config gql.Config) error {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: Controller.UserQueries()}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: Controller.UserMutations()}),
+	})
+	if err != nil {
+		return err
+	}
+
+	handler := gql.Handler(schema, config)
+	// GraphQL serves the users too, it needs a token like their REST routes and honours Idempotency-Key like them
+	router.Get("/graphql", providers.Authenticate, handler)
+	router.Post("/graphql", providers.Authenticate, providers.Idempotent, handler)
+	return nil
+}